@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"runtime/pprof"
+
+	"pngcam-go-render/render"
 )
 
 func main() {
@@ -46,18 +48,18 @@ func main() {
 		*png = stlFile + ".png"
 	}
 
-	opt := Options{
-		width:   *width,
-		height:  *height,
-		bottom:  *bottom,
-		quiet:   *quiet,
-		stlFile: stlFile,
-		pngFile: *png,
-		rotary:  *rotary,
-		rgb:     *rgb,
+	opt := render.Options{
+		Width:   *width,
+		Height:  *height,
+		Bottom:  *bottom,
+		Quiet:   *quiet,
+		STLFile: stlFile,
+		PNGFile: *png,
+		Rotary:  *rotary,
+		RGB:     *rgb,
 	}
 
-	renderer, err := NewRenderer(&opt)
+	renderer, err := render.NewRenderer(&opt)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
@@ -1,12 +0,0 @@
-package main
-
-type Options struct {
-	width   int
-	height  int
-	bottom  bool
-	quiet   bool
-	stlFile string
-	pngFile string
-	rotary  bool
-	rgb     bool
-}
@@ -0,0 +1,12 @@
+// Package render rasterises STL meshes into heightmaps for pngcam, either
+// as PNG files or as heights in memory:
+//
+//	r, err := render.NewRenderer(&render.Options{STLFile: "part.stl", PxPerMm: 10})
+//	if err != nil {
+//		return err
+//	}
+//	r.Draw()
+//	hm := r.Heightmap()
+//	w, h := hm.Size()
+//	z := hm.HeightMm(w/2, h/2)
+package render
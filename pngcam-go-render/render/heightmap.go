@@ -1,26 +1,48 @@
-package main
+package render
 
 import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
 	"image"
 	"image/png"
+	"io"
 	"math"
 	"os"
+	"strconv"
 )
 
+// Heightmap holds the height of each pixel as a fraction of the depth of the
+// part, from 0 (the bottom, or the axis in rotary mode) to 1 (the top)
 type Heightmap struct {
 	height  []float32
 	options *Options
+
+	// physical size of the part, written into the PNG so that pngcam doesn't
+	// need to be told it again
+	mmWidth  float32
+	mmHeight float32
+	mmDepth  float32
 }
 
+// keys of the PNG tEXt chunks that describe the part; pngcam reads these back
+const (
+	TextWidth  = "pngcam-width"
+	TextHeight = "pngcam-height"
+	TextDepth  = "pngcam-depth"
+	TextRotary = "pngcam-rotary"
+	TextSide   = "pngcam-side"
+)
+
 func NewHeightmap(opt *Options) *Heightmap {
 	hm := Heightmap{}
-	hm.height = make([]float32, opt.width*opt.height)
+	hm.height = make([]float32, opt.Width*opt.Height)
 	hm.options = opt
 
 	// initialise to minimum height
-	for y := 0; y < opt.height; y++ {
-		for x := 0; x < opt.width; x++ {
-			n := y*opt.width + x
+	for y := 0; y < opt.Height; y++ {
+		for x := 0; x < opt.Width; x++ {
+			n := y*opt.Width + x
 			hm.height[n] = 0
 		}
 	}
@@ -28,14 +50,27 @@ func NewHeightmap(opt *Options) *Heightmap {
 	return &hm
 }
 
+// WritePNG writes the heightmap to a PNG file, as for Encode()
 func (hm *Heightmap) WritePNG(path string) error {
+	buf := bytes.Buffer{}
+	err := hm.Encode(&buf)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Encode writes the heightmap as a PNG, in greyscale or 24-bit colour (see
+// Options.RGB), with the size of the part in tEXt chunks (see Text())
+func (hm *Heightmap) Encode(w io.Writer) error {
 	opt := hm.options
 
-	img := image.NewRGBA(image.Rect(0, 0, opt.width, opt.height))
+	img := image.NewRGBA(image.Rect(0, 0, opt.Width, opt.Height))
 
-	for y := 0; y < opt.height; y++ {
-		for x := 0; x < opt.width; x++ {
-			n := y*opt.width + x
+	for y := 0; y < opt.Height; y++ {
+		for x := 0; x < opt.Width; x++ {
+			n := y*opt.Width + x
 
 			z := hm.height[n]
 			if z > 1 {
@@ -46,7 +81,7 @@ func (hm *Heightmap) WritePNG(path string) error {
 			}
 			brightness := int(16777215 * z)
 
-			if opt.rgb {
+			if opt.RGB {
 				img.Pix[n*4] = uint8(brightness >> 16)
 				img.Pix[n*4+1] = uint8((brightness >> 8) & 0xff)
 				img.Pix[n*4+2] = uint8(brightness & 0xff)
@@ -59,15 +94,76 @@ func (hm *Heightmap) WritePNG(path string) error {
 		}
 	}
 
-	out, err := os.Create(path)
+	buf := bytes.Buffer{}
+	err := png.Encode(&buf, img)
 	if err != nil {
 		return err
 	}
-	png.Encode(out, img)
-	out.Close()
+
+	_, err = w.Write(hm.insertText(buf.Bytes()))
 	return err
 }
 
+// Text returns the tEXt chunks to describe the part; rotary parts are always
+// 360 degrees around, so they don't get a height
+func (hm *Heightmap) Text() [][2]string {
+	formatMm := func(v float32) string {
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	}
+
+	side := "top"
+	if hm.options.Bottom {
+		side = "bottom"
+	}
+
+	text := [][2]string{{TextWidth, formatMm(hm.mmWidth)}}
+	if !hm.options.Rotary {
+		text = append(text, [2]string{TextHeight, formatMm(hm.mmHeight)})
+	}
+	text = append(text, [2]string{TextDepth, formatMm(hm.mmDepth)})
+	text = append(text, [2]string{TextRotary, strconv.FormatBool(hm.options.Rotary)})
+	text = append(text, [2]string{TextSide, side})
+
+	return text
+}
+
+// insertText adds our tEXt chunks to the encoded PNG, straight after the
+// IHDR chunk (which is always first, and always 13 bytes long)
+func (hm *Heightmap) insertText(encoded []byte) []byte {
+	ihdrEnd := 8 + 4 + 4 + 13 + 4 // signature, length, type, data, CRC
+
+	out := bytes.Buffer{}
+	out.Write(encoded[:ihdrEnd])
+	for _, kv := range hm.Text() {
+		data := []byte(kv[0] + "\x00" + kv[1])
+		binary.Write(&out, binary.BigEndian, uint32(len(data)))
+		chunk := append([]byte("tEXt"), data...)
+		out.Write(chunk)
+		binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	}
+	out.Write(encoded[ihdrEnd:])
+
+	return out.Bytes()
+}
+
+// Size returns the width and height in pixels
+func (hm *Heightmap) Size() (int, int) {
+	return hm.options.Width, hm.options.Height
+}
+
+// HeightMm returns the height at (x,y) in mm above the bottom of the part (or
+// above the axis, for rotary parts), clamped in the same way as WritePNG()
+func (hm *Heightmap) HeightMm(x, y int) float64 {
+	z := hm.height[y*hm.options.Width+x]
+	if z > 1 {
+		z = 1
+	}
+	if z < 0 {
+		z = 0
+	}
+	return float64(z) * float64(hm.mmDepth)
+}
+
 // X,Y should be in pixels
 // Z should range from 0..1
 func (hm *Heightmap) DrawTriangle(a, b, c [3]float32) {
@@ -79,7 +175,7 @@ func (hm *Heightmap) DrawTriangle(a, b, c [3]float32) {
 	rightZ := make(map[int]float32)
 
 	// min/max Y position
-	minY := hm.options.height
+	minY := hm.options.Height
 	maxY := -1
 
 	// 1. work out where the outline of the triangle is
@@ -129,7 +225,7 @@ func (hm *Heightmap) DrawTriangle(a, b, c [3]float32) {
 // Z should range from 0..1
 func (hm *Heightmap) DrawTriangleOnOneLine(a, b, c [3]float32, ycoord int) {
 	// min/max X position for the central Y position
-	leftX := hm.options.width
+	leftX := hm.options.Width
 	rightX := 0
 	// Z coordinate for corresponding leftX/rightX
 	leftZ := float32(0)
@@ -170,7 +266,7 @@ func (hm *Heightmap) DrawTriangleOnOneLine(a, b, c [3]float32, ycoord int) {
 	hm.IterateLine(b, c, perimeterCb)
 	hm.IterateLine(c, a, perimeterCb)
 
-	if leftX >= hm.options.width {
+	if leftX >= hm.options.Width {
 		return
 	}
 
@@ -191,11 +287,11 @@ func (hm *Heightmap) DrawTriangleOnOneLine(a, b, c [3]float32, ycoord int) {
 func (hm *Heightmap) PlotPixel(x, y int, z float32) {
 	opt := hm.options
 
-	if x < 0 || x >= opt.width || y < 0 || y >= opt.height {
+	if x < 0 || x >= opt.Width || y < 0 || y >= opt.Height {
 		return
 	}
 
-	n := y*opt.width + x
+	n := y*opt.Width + x
 
 	if z > hm.height[n] {
 		hm.height[n] = z
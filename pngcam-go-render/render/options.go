@@ -0,0 +1,17 @@
+package render
+
+type Options struct {
+	// size of the heightmap in pixels
+	Width  int
+	Height int
+
+	Bottom  bool // view from the bottom, rotated 180 degrees around Y
+	Quiet   bool // don't write dimensions and progress to stderr
+	STLFile string
+	PNGFile string
+	Rotary  bool // render all the way around the X axis
+	RGB     bool // use R, G, and B for 24 bits of height instead of 8
+
+	// if non-zero, Width and Height are worked out from the size of the part
+	PxPerMm float64
+}
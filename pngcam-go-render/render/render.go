@@ -1,4 +1,4 @@
-package main
+package render
 
 import (
 	"fmt"
@@ -8,6 +8,8 @@ import (
 	"github.com/hschendel/stl"
 )
 
+// A Renderer draws an STL mesh into a Heightmap, looking down the Z axis, or
+// all the way around the X axis in rotary mode
 type Renderer struct {
 	options   Options
 	mesh      *stl.Solid
@@ -23,32 +25,56 @@ const (
 	Z = 2
 )
 
+// NewRenderer reads the mesh from opt.STLFile
 func NewRenderer(opt *Options) (*Renderer, error) {
-	r := Renderer{}
-	r.options = *opt
-
-	solid, err := stl.ReadFile(opt.stlFile)
+	solid, err := stl.ReadFile(opt.STLFile)
 	if err != nil {
 		return nil, err
 	}
+
+	return NewRendererFromSolid(solid, opt), nil
+}
+
+// NewRendererFromSolid renders a mesh that is already in memory; the mesh is
+// moved and rotated in place
+func NewRendererFromSolid(solid *stl.Solid, opt *Options) *Renderer {
+	r := Renderer{}
+	r.options = *opt
 	r.mesh = solid
 
 	r.ProcessMesh()
 
-	if !opt.quiet {
-		fmt.Fprintf(os.Stderr, "%dx%d px depth map. %gx%g mm work piece.\n", opt.width, opt.height, r.mmWidth, r.mmHeight)
+	// work out the size in pixels from the size of the part, rounding the
+	// work piece up to a whole number of pixels so that they stay square;
+	// rotary parts go all the way around at the largest radius
+	if opt.PxPerMm > 0 {
+		r.options.Width = int(math.Max(1, math.Ceil(float64(r.mmWidth)*opt.PxPerMm)))
+		r.mmWidth = float32(float64(r.options.Width) / opt.PxPerMm)
+		if opt.Rotary {
+			r.options.Height = int(math.Max(1, math.Round(2*math.Pi*float64(r.mmDepth)*opt.PxPerMm)))
+		} else {
+			r.options.Height = int(math.Max(1, math.Ceil(float64(r.mmHeight)*opt.PxPerMm)))
+			r.mmHeight = float32(float64(r.options.Height) / opt.PxPerMm)
+		}
+	}
+
+	if !opt.Quiet {
+		fmt.Fprintf(os.Stderr, "%dx%d px depth map. %gx%g mm work piece.\n", r.options.Width, r.options.Height, r.mmWidth, r.mmHeight)
 		fmt.Fprintf(os.Stderr, "Work piece is %g tall in Z axis.\n", r.mmDepth)
-		fmt.Fprintf(os.Stderr, "X resolution is %g px/mm. Y resolution is %g px/mm.\n", float32(opt.width)/r.mmWidth, float32(opt.height)/r.mmHeight)
+		fmt.Fprintf(os.Stderr, "X resolution is %g px/mm. Y resolution is %g px/mm.\n", float32(r.options.Width)/r.mmWidth, float32(r.options.Height)/r.mmHeight)
 	}
 
-	r.heightmap = NewHeightmap(opt)
+	r.heightmap = NewHeightmap(&r.options)
+	r.heightmap.mmWidth = r.mmWidth
+	r.heightmap.mmHeight = r.mmHeight
+	r.heightmap.mmDepth = r.mmDepth
 
-	return &r, nil
+	return &r
 }
 
 func (r *Renderer) ProcessMesh() {
 	// rotate to the required side
-	if r.options.bottom {
+	if r.options.Bottom {
 		r.mesh.Rotate(stl.Vec3{0, 0, 0}, stl.Vec3{0, 1, 0}, stl.Pi)
 	}
 
@@ -83,7 +109,7 @@ func (r *Renderer) ProcessMesh() {
 			if v[Z] > max[Z] {
 				max[Z] = v[Z]
 			}
-			if r.options.rotary {
+			if r.options.Rotary {
 				r := math.Sqrt(float64(v[Y]*v[Y] + v[Z]*v[Z]))
 				if r > maxRadius {
 					maxRadius = r
@@ -97,7 +123,7 @@ func (r *Renderer) ProcessMesh() {
 	r.mmDepth = max[Z] - min[Z]
 
 	// translate to origin
-	if r.options.rotary {
+	if r.options.Rotary {
 		min[X] = -min[X]
 		min[Y] = 0
 		min[Z] = 0
@@ -112,21 +138,32 @@ func (r *Renderer) ProcessMesh() {
 	}
 }
 
+// Render draws the heightmap and writes it to the PNG file
 func (r *Renderer) Render() {
-	if r.options.rotary {
+	r.Draw()
+
+	err := r.heightmap.WritePNG(r.options.PNGFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "write %s: %v\n", r.options.PNGFile, err)
+	}
+}
+
+// Draw draws the heightmap, without writing it anywhere
+func (r *Renderer) Draw() {
+	if r.options.Rotary {
 		r.RenderRotary()
 	} else {
 		r.RenderFlat()
 	}
 
-	if !r.options.quiet {
+	if !r.options.Quiet {
 		fmt.Fprintf(os.Stderr, "   \rDrawing triangles: done.\n")
 	}
+}
 
-	err := r.heightmap.WritePNG(r.options.pngFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "write %s: %v\n", r.options.pngFile, err)
-	}
+// Heightmap returns the heightmap, which is empty until Draw() or Render()
+func (r *Renderer) Heightmap() *Heightmap {
+	return r.heightmap
 }
 
 func (r *Renderer) RenderFlat() {
@@ -134,7 +171,7 @@ func (r *Renderer) RenderFlat() {
 		t := r.mesh.Triangles[i]
 		r.heightmap.DrawTriangle(r.MmToPx(t.Vertices[0]), r.MmToPx(t.Vertices[1]), r.MmToPx(t.Vertices[2]))
 
-		if !r.options.quiet {
+		if !r.options.Quiet {
 			pct := 100.0 * float64(i) / float64(len(r.mesh.Triangles))
 			fmt.Fprintf(os.Stderr, "   \rDrawing triangles: %.0f%%", pct)
 		}
@@ -142,8 +179,8 @@ func (r *Renderer) RenderFlat() {
 }
 
 func (r *Renderer) RenderRotary() {
-	for ypx := 0; ypx < r.options.height; ypx += 1 {
-		angle := 2 * stl.Pi * float64(ypx) / float64(r.options.height)
+	for ypx := 0; ypx < r.options.Height; ypx += 1 {
+		angle := 2 * stl.Pi * float64(ypx) / float64(r.options.Height)
 
 		r.mesh.Rotate(stl.Vec3{0, 0, 0}, stl.Vec3{1, 0, 0}, angle)
 		for i := range r.mesh.Triangles {
@@ -152,8 +189,8 @@ func (r *Renderer) RenderRotary() {
 		}
 		r.mesh.Rotate(stl.Vec3{0, 0, 0}, stl.Vec3{1, 0, 0}, -angle)
 
-		if !r.options.quiet {
-			pct := 100.0 * float64(ypx) / float64(r.options.height)
+		if !r.options.Quiet {
+			pct := 100.0 * float64(ypx) / float64(r.options.Height)
 			fmt.Fprintf(os.Stderr, "   \rDrawing triangles: %.0f%%", pct)
 		}
 	}
@@ -161,13 +198,13 @@ func (r *Renderer) RenderRotary() {
 
 func (r *Renderer) MmToPx(v stl.Vec3) [3]float32 {
 	var vNew [3]float32
-	if r.options.rotary {
-		vNew[X] = v[X] * float32(r.options.width) / r.mmWidth
-		vNew[Y] = -v[Y] * float32(r.options.height) / r.mmHeight
+	if r.options.Rotary {
+		vNew[X] = v[X] * float32(r.options.Width) / r.mmWidth
+		vNew[Y] = -v[Y] * float32(r.options.Height) / r.mmHeight
 		vNew[Z] = v[Z] / r.mmDepth
 	} else {
-		vNew[X] = v[X] * float32(r.options.width) / r.mmWidth
-		vNew[Y] = float32(r.options.height-1) - v[Y]*float32(r.options.height)/r.mmHeight
+		vNew[X] = v[X] * float32(r.options.Width) / r.mmWidth
+		vNew[Y] = float32(r.options.Height-1) - v[Y]*float32(r.options.Height)/r.mmHeight
 		vNew[Z] = v[Z] / r.mmDepth
 	}
 	return vNew
@@ -1,3 +1,9 @@
 module pngcam-go
 
 go 1.20
+
+require pngcam-go-render v0.0.0
+
+require github.com/hschendel/stl v1.0.4 // indirect
+
+replace pngcam-go-render => ../pngcam-go-render
@@ -5,14 +5,28 @@ import (
 	"fmt"
 	"os"
 	"runtime/pprof"
+
+	"pngcam-go/pngcam"
 )
 
 func main() {
-	toolShape := flag.String("tool-shape", "ball", "Set the shape of the end mill.")
+	toolShape := flag.String("tool-shape", "ball", "Set the shape of the end mill: ball, flat, vbitANGLE for a V-bit with the given included angle in degrees (e.g. vbit60), vbitANGLEtipDIAMETER for a V-bit with a flat tip of the given diameter in mm (e.g. vbit60tip0.2), bullRADIUS for a bull-nose end mill with the given corner radius in mm (e.g. bull1.0), or taperballANGLEtipDIAMETER for a tapered ball-nose with the given angle between side and axis in degrees and ball diameter in mm (e.g. taperball3.6tip0.5), where --tool-diameter is the shank diameter.")
 	toolDiameter := flag.Float64("tool-diameter", 6, "Set the diameter of the end mill in mm.")
+	toolProfile := flag.String("tool-profile", "", "Read the shape of the end mill from a CSV file of radius,height pairs in mm, instead of using --tool-shape and --tool-diameter. Heights are measured up from the tip, starting at radius 0, with straight lines in between.")
+
+	toolName := flag.String("tool", "", "Use the named tool from the tool library, which sets the tool shape and diameter, tool number, spindle speed, feed rates, step-over, and step-down. Any of these that are given explicitly take priority.")
+	toolLibrary := flag.String("tool-library", "", "Read --tool from this JSON file, instead of pngcam/tools.json in the user's config directory (e.g. ~/.config/pngcam/tools.json).")
+	fluteLength := flag.Float64("flute-length", 0, "Set the length of the cutting part of the end mill in mm. With --shank-diameter, toolpoints are lifted where the shank would otherwise hit the part. 0 means unknown.")
+	shankDiameter := flag.Float64("shank-diameter", 0, "Set the diameter of the shank above the flutes in mm, for collision checking with --flute-length.")
+	stickOut := flag.Float64("stick-out", 0, "Set the length of the tool sticking out of the holder in mm, measured from the tip. With --holder-diameter, toolpoints are lifted where the holder would otherwise hit the part. 0 means unknown.")
+	holderDiameter := flag.Float64("holder-diameter", 0, "Set the diameter of the tool holder (or collet nut) in mm, for collision checking with --stick-out.")
+	failOnCollision := flag.Bool("fail-on-collision", false, "Fail with a list of the colliding locations if the shank or holder would hit the part, instead of lifting the tool clear and leaving rest material.")
+	toolNumber := flag.Int("tool-number", 0, "Select this tool with a tool change (T M6) at the start of the program. 0 means no tool change.")
 
 	stepDown := flag.Float64("step-down", 100, "Set the maximum step-down in mm. Where the natural toolpath would exceed a cut of this depth, multiple passes are taken instead.")
 	stepOver := flag.Float64("step-over", 5, "Set the distance to move the tool over per pass in mm.")
+	stepForward := flag.Float64("step-forward", 0, "Set the distance to step forward for each point in the path, in mm (or degrees, around the rotary axis). If the part contains features that are substantially smaller than the step-over, then you can use --step-forward to make sure you don't cut through them. 0 means 1 pixel.")
+	tolerance := flag.Float64("tolerance", 0, "Set the distance in mm that the simplified toolpath may deviate from the computed toolpoints. The toolpath is never allowed to pass below the toolpoints. 0 only removes exactly collinear points.")
 	xyFeed := flag.Float64("xy-feed-rate", 400, "Set the maximum feed rate in X/Y plane in mm/min.")
 	zFeed := flag.Float64("z-feed-rate", 50, "Set the maximum feed rate in Z axis in mm/min.")
 	rapidFeed := flag.Float64("rapid-feed-rate", 10000, "Set the maximum feed rate for rapid travel moves in mm/min.")
@@ -21,30 +35,48 @@ func main() {
 	roughingOnly := flag.Bool("roughing-only", false, "Only do the roughing pass (based on --step-down) and do not do the finish pass. This is useful if you want to use different parameters, or a different tool, for the roughing pass comapred to the finish pass.")
 	clearance := flag.Float64("clearance", 0, "Set the clearance to leave around the part in mm. Intended so that you can come back again with a finish pass to clean up the part.")
 	safeZ := flag.Float64("rapid-clearance", 5, "Set the Z clearance to leave above the part during rapid moves.")
-	route := flag.String("route", "horizontal", "Set whether the tool will move in horizontal, vertical, or helical lines.")
+	route := flag.String("route", "horizontal", "Set whether the tool will move in horizontal, vertical, or helical lines. Give a comma-separated list to cut multiple routes one after the other, or \"both\" for horizontal followed by vertical.")
 	xOffset := flag.Float64("x-offset", 0, "Set the offset to add to X coordinates.")
 	yOffset := flag.Float64("y-offset", 0, "Set the offset to add to Y coordinates.")
 	zOffset := flag.Float64("z-offset", 0, "Set the offset to add to Z coordinates.")
 	rampEntry := flag.Bool("ramp-entry", false, "Add horizontal movements to plunge cuts where possible, to reduce cutting forces.")
 
-	width := flag.Float64("width", 100, "Set the width of the image in mm.")
-	height := flag.Float64("height", 100, "Set the height of the image in mm.")
-	depth := flag.Float64("depth", 10, "Set the total depth of the part in mm.")
+	width := flag.Float64("width", 0, "Set the width of the image in mm. If height is not specified, height will be calculated automatically to maintain aspect ratio. If neither are specified, width=100mm is assumed.")
+	height := flag.Float64("height", 0, "Set the height of the image in mm. If width is not specified, width will be calculated automatically to maintain aspect ratio. If neither are specified, width=100mm is assumed.")
+	depth := flag.Float64("depth", 10, "Set the total depth of the part in mm. Heightmaps from pngcam-render carry their own width, height, depth, and rotary flag, which are used unless given here.")
 	diameter := flag.Float64("diameter", 0, "Set the diameter of the part for rotary carving.")
 	rotary := flag.Bool("rotary", false, "Rotary carving.")
+	xFlip := flag.Bool("x-flip", false, "Flip the image in the X axis. This is useful when you want to cut the same shape on the bottom of a part. The origin will still be at top left of the finished toolpath.")
+	yFlip := flag.Bool("y-flip", false, "Flip the image in the Y axis. This is useful when you want to cut the same shape on the bottom of a part. The origin will still be at top left of the finished toolpath.")
+	invert := flag.Bool("invert", false, "Invert the colours in the image, so that white is the deepest cut and black is the shallowest.")
+	normalise := flag.Bool("normalise", false, "Measure the minimum and maximum brightness in the heightmap and stretch all brightness so that the full range of cut depth is achieved.")
+	normaliseIgnoreBlack := flag.Bool("normalise-ignore-black", false, "Normalise, but ignore black (i.e. stretch all brightnesses apart from black, but leave black alone). Normalisation is applied before inversion.")
 
 	cutBelowBottom := flag.Bool("deep-black", false, "Let the tool cut below the full depth if this would allow better reproduction of the non-black parts of the heightmap. Only really applicable with a ball-nose end mill.")
 	cutBeyondEdges := flag.Bool("beyond-edges", false, "Let the tool cut beyond the edges of the heightmap.")
 	omitTop := flag.Bool("omit-top", false, "Don't bother cutting top surfaces that are at the upper limit of the heightmap.")
 	omitBottom := flag.Bool("omit-bottom", false, "Don't bother cutting bottom surfaces that are at the lower limit of the heightmap.")
+	precompute := flag.Bool("precompute", false, "Compute the tool position for every pixel of the heightmap up front, using all CPU cores, instead of only as the toolpath needs them. This is usually faster on multi-core machines unless the step-over is many pixels.")
+	cacheDir := flag.String("cache-dir", "", "Keep the computed tool positions in this directory, so that later runs with the same heightmap, tool, depth, clearance, --deep-black, and resolution can skip computing them again.")
 	imperial := flag.Bool("imperial", false, "All units in inches instead of mm, and inches/min instead of mm/min. G-code output has G20 instead of G21.")
 
-	readStockPath := flag.String("read-stock", "", "Read stock heightmap from PNG file, to save cutting air in roughing passes.")
-	writeStockPath := flag.String("write-stock", "", "Write output heightmap to PNG file, to use with --read-stock.")
+	stlResolution := flag.Float64("stl-resolution", 10, "Set the resolution in px/mm at which to render STL heightmaps. The part is rendered from the top, or all the way around in rotary mode.")
+
+	readStockPath := flag.String("read-stock", "", "Read stock heightmap from file, to save cutting air in roughing passes. The format is chosen by extension, as for the input heightmap.")
+	writeStockPath := flag.String("write-stock", "", "Write output heightmap to file, to use with --read-stock. The format is chosen by extension: .tif (32-bit float), .pfm, .raw (32-bit float, with a .hdr sidecar), .pgm (16-bit), or PNG for anything else.")
 	rgb := flag.Bool("rgb", false, "Use full 24-bit colour when writing output heightmap.")
+	grey16 := flag.Bool("grey16", false, "Use 16-bit greyscale when writing output heightmap. (Input heightmaps are detected automatically.)")
+
+	postName := flag.String("post", "linuxcnc", "Set the G-code dialect to output: linuxcnc, grbl, or fanuc.")
+	arcTolerance := flag.Float64("arc-tolerance", 0, "Replace runs of points that lie on an arc, to within this distance in mm, with G2/G3 moves. 0 disables arc fitting.")
 
 	maxVel := flag.Float64("max-vel", 4000, "Max. velocity in mm/min for cycle time estimation.")
 	maxAccel := flag.Float64("max-accel", 50, "Max. acceleration in mm/sec^2 for cycle time estimation.")
+	maxZVel := flag.Float64("max-z-vel", 0, "Max. velocity of the Z axis in mm/min for cycle time estimation. 0 means the same as --max-vel.")
+	maxZAccel := flag.Float64("max-z-accel", 0, "Max. acceleration of the Z axis in mm/sec^2 for cycle time estimation. 0 means the same as --max-accel.")
+	maxAVel := flag.Float64("max-a-vel", 0, "Max. velocity of the rotary axis in degrees/min for cycle time estimation. 0 means unlimited.")
+	maxAAccel := flag.Float64("max-a-accel", 0, "Max. acceleration of the rotary axis in degrees/sec^2 for cycle time estimation. 0 means unlimited.")
+	junctionDeviation := flag.Float64("junction-deviation", 0.01, "Junction deviation in mm, used to limit cornering speed for cycle time estimation.")
 
 	quiet := flag.Bool("quiet", false, "Suppress output of dimensions, resolutions, and progress.")
 
@@ -68,27 +100,63 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	tool, err := NewTool(*toolShape, *toolDiameter)
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	var entry *pngcam.ToolLibraryEntry
+	if *toolName != "" {
+		if *toolLibrary == "" {
+			*toolLibrary = pngcam.DefaultToolLibraryPath()
+		}
+		lib, err := pngcam.LoadToolLibrary(*toolLibrary)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		entry, err = lib.Get(*toolName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *toolLibrary, err)
+			os.Exit(1)
+		}
+
+		// with only one of the shape and diameter given, take the other
+		// one from the library
+		if setFlags["tool-shape"] && !setFlags["tool-diameter"] && entry.Diameter != 0 {
+			*toolDiameter = entry.Diameter
+		} else if setFlags["tool-diameter"] && !setFlags["tool-shape"] && !setFlags["tool-profile"] && entry.Shape != "" {
+			*toolShape = entry.ToolShape()
+		}
+	}
+
+	var tool pngcam.Tool
+	var err error
+	if *toolProfile != "" {
+		tool, err = pngcam.LoadToolProfile(*toolProfile)
+	} else {
+		tool, err = pngcam.NewTool(*toolShape, *toolDiameter)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	dir := Horizontal
-	if *route == "vertical" {
-		dir = Vertical
-	} else if *route == "horizontal" {
-		dir = Horizontal
-	} else if *route == "helical" {
-		dir = Helical
-	} else {
-		fmt.Fprintf(os.Stderr, "unrecognised route: %s\n", *route)
+	post, err := pngcam.NewPostProcessor(*postName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	dirs, err := pngcam.ParseRoute(*route)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
 	args := flag.Args()
 	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "usage: pngcam HEIGHTMAPFILE\n")
+		fmt.Fprintf(os.Stderr, "usage: pngcam HEIGHTMAPFILE|STLFILE\n")
 		os.Exit(1)
 	}
 	heightmapPath := args[0]
@@ -99,68 +167,99 @@ func main() {
 			os.Exit(1)
 		}
 		*depth = *diameter / 2.0
+		setFlags["depth"] = true
 	}
 
-	if *rotary {
-		// rotary parts are always 360 degrees around (should this be configurable? e.g. to allow partial rotation?)
-		*height = 360.0
-		*safeZ += *depth
-	} else {
-		if dir == Helical {
-			fmt.Fprintf(os.Stderr, "can't use helical paths in non-rotary mode")
-		}
+	opt := pngcam.Options{
+		HeightmapPath:  heightmapPath,
+		Explicit:       setFlags,
+		ReadStockPath:  *readStockPath,
+		WriteStockPath: *writeStockPath,
+		RGB:            *rgb,
+		Grey16:         *grey16,
+		STLResolution:  *stlResolution,
+
+		SafeZ:     *safeZ,
+		RapidFeed: *rapidFeed,
+		XYFeed:    *xyFeed,
+		ZFeed:     *zFeed,
+		RPM:       *rpm,
+
+		Width:  *width,
+		Height: *height,
+		Depth:  *depth,
+		Rotary: *rotary,
+		XFlip:  *xFlip,
+		YFlip:  *yFlip,
+		Invert: *invert,
+
+		Normalise:            *normalise || *normaliseIgnoreBlack,
+		NormaliseIgnoreBlack: *normaliseIgnoreBlack,
+
+		Directions: dirs,
+
+		StepOver:    *stepOver,
+		StepDown:    *stepDown,
+		StepForward: *stepForward,
+		Tolerance:   *tolerance,
+
+		Tool:            tool,
+		ToolNumber:      *toolNumber,
+		FluteLength:     *fluteLength,
+		ShankDiameter:   *shankDiameter,
+		StickOut:        *stickOut,
+		HolderDiameter:  *holderDiameter,
+		FailOnCollision: *failOnCollision,
+
+		Post:         post,
+		ArcTolerance: *arcTolerance,
+
+		StockToLeave: *clearance,
+
+		Precompute: *precompute,
+		CacheDir:   *cacheDir,
+
+		RoughingOnly:   *roughingOnly,
+		OmitTop:        *omitTop,
+		OmitBottom:     *omitBottom,
+		RampEntry:      *rampEntry,
+		CutBelowBottom: *cutBelowBottom,
+		CutBeyondEdges: *cutBeyondEdges,
+
+		Imperial: *imperial,
+
+		XOffset: *xOffset,
+		YOffset: *yOffset,
+		ZOffset: *zOffset,
+
+		MaxVel:            *maxVel,
+		MaxAccel:          *maxAccel,
+		MaxZVel:           *maxZVel,
+		MaxZAccel:         *maxZAccel,
+		MaxAVel:           *maxAVel,
+		MaxAAccel:         *maxAAccel,
+		JunctionDeviation: *junctionDeviation,
+
+		Quiet: *quiet,
 	}
 
-	opt := Options{
-		heightmapPath:  heightmapPath,
-		readStockPath:  *readStockPath,
-		writeStockPath: *writeStockPath,
-		rgb:            *rgb,
-
-		safeZ:     *safeZ,
-		rapidFeed: *rapidFeed,
-		xyFeed:    *xyFeed,
-		zFeed:     *zFeed,
-		rpm:       *rpm,
-
-		width:  *width,
-		height: *height,
-		depth:  *depth,
-		rotary: *rotary,
-
-		direction: dir,
-
-		stepOver: *stepOver,
-		stepDown: *stepDown,
-
-		tool: tool,
-
-		stockToLeave: *clearance,
-
-		roughingOnly:   *roughingOnly,
-		omitTop:        *omitTop,
-		omitBottom:     *omitBottom,
-		rampEntry:      *rampEntry,
-		cutBelowBottom: *cutBelowBottom,
-		cutBeyondEdges: *cutBeyondEdges,
-
-		imperial: *imperial,
-
-		xOffset: *xOffset,
-		yOffset: *yOffset,
-		zOffset: *zOffset,
-
-		maxVel:   *maxVel,
-		maxAccel: *maxAccel,
-
-		quiet: *quiet,
+	if entry != nil {
+		err = entry.Apply(&opt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: tool %s: %v\n", *toolLibrary, *toolName, err)
+			os.Exit(1)
+		}
 	}
 
-	job, err := NewJob(&opt)
+	job, err := pngcam.NewJob(&opt)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	os.Stdout.WriteString(job.Gcode())
+	err = job.WriteGcode(os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 }
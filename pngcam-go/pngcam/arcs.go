@@ -0,0 +1,150 @@
+package pngcam
+
+import (
+	"math"
+)
+
+type Plane int
+
+const (
+	PlaneXY Plane = iota // G17
+	PlaneZX              // G18
+	PlaneYZ              // G19
+)
+
+type Arc struct {
+	plane     Plane
+	end       Toolpoint
+	i         float64 // X offset from start point to centre
+	j         float64 // Y offset from start point to centre
+	k         float64 // Z offset from start point to centre
+	clockwise bool
+}
+
+// planeCoords returns the 2 in-plane coordinates of p, in the order that
+// G-code uses to define arc directions, followed by the out-of-plane
+// coordinate
+func planeCoords(p Toolpoint, plane Plane) (float64, float64, float64) {
+	if plane == PlaneXY {
+		return p.X, p.Y, p.Z
+	} else if plane == PlaneZX {
+		return p.Z, p.X, p.Y
+	} else {
+		return p.Y, p.Z, p.X
+	}
+}
+
+// FitArc finds the longest run of points, starting at seg.points[start], that
+// lie on a single arc in one of the principal planes, to within tolerance;
+// it returns the arc and the index of the last point it replaces, or false
+// if no arc could be found
+func (seg *ToolpathSegment) FitArc(start int, tolerance float64) (Arc, int, bool) {
+	bestArc := Arc{}
+	bestEnd := -1
+
+	for _, plane := range []Plane{PlaneXY, PlaneZX, PlaneYZ} {
+		_, _, w0 := planeCoords(seg.points[start], plane)
+
+		for end := start + 1; end < len(seg.points); end++ {
+			_, _, w := planeCoords(seg.points[end], plane)
+			if seg.points[end].Feed != CuttingFeed || math.Abs(w-w0) > 0.00001 {
+				break
+			}
+			if end-start < 2 {
+				// need at least 3 points to define an arc
+				continue
+			}
+
+			arc, ok := fitArcThrough(seg.points[start:end+1], plane, tolerance)
+			if !ok {
+				break
+			}
+			if end > bestEnd {
+				bestArc = arc
+				bestEnd = end
+			}
+		}
+	}
+
+	return bestArc, bestEnd, bestEnd >= 0
+}
+
+// fitArcThrough tries to fit an arc through pts, all of which must already be
+// known to lie in the given plane; the circle passes through the first,
+// middle, and last points, and is accepted if every point, and the midpoint
+// of every line between points, is within tolerance of it
+func fitArcThrough(pts []Toolpoint, plane Plane, tolerance float64) (Arc, bool) {
+	n := len(pts)
+	ax, ay, _ := planeCoords(pts[0], plane)
+	bx, by, _ := planeCoords(pts[n/2], plane)
+	cx, cy, _ := planeCoords(pts[n-1], plane)
+
+	// circle through 3 points: https://en.wikipedia.org/wiki/Circumscribed_circle#Cartesian_coordinates_2
+	d := 2 * (ax*(by-cy) + bx*(cy-ay) + cx*(ay-by))
+	if math.Abs(d) < 1e-12 {
+		// collinear
+		return Arc{}, false
+	}
+	aSqr := ax*ax + ay*ay
+	bSqr := bx*bx + by*by
+	cSqr := cx*cx + cy*cy
+	ux := (aSqr*(by-cy) + bSqr*(cy-ay) + cSqr*(ay-by)) / d
+	uy := (aSqr*(cx-bx) + bSqr*(ax-cx) + cSqr*(bx-ax)) / d
+	r := math.Hypot(ax-ux, ay-uy)
+
+	// a huge radius means the points are very nearly a straight line, and
+	// the resulting I/J/K words would be unwieldy
+	maxRadius := 10000.0
+	if r > maxRadius {
+		return Arc{}, false
+	}
+
+	direction := 0.0
+	sweep := 0.0
+
+	for i := 1; i < n; i++ {
+		px, py, _ := planeCoords(pts[i-1], plane)
+		qx, qy, _ := planeCoords(pts[i], plane)
+
+		if math.Abs(math.Hypot(qx-ux, qy-uy)-r) > tolerance {
+			return Arc{}, false
+		}
+		if math.Abs(math.Hypot((px+qx)/2-ux, (py+qy)/2-uy)-r) > tolerance {
+			return Arc{}, false
+		}
+
+		// every step has to go around the circle in the same direction
+		cross := (px-ux)*(qy-uy) - (py-uy)*(qx-ux)
+		dot := (px-ux)*(qx-ux) + (py-uy)*(qy-uy)
+		angle := math.Atan2(cross, dot)
+		if angle == 0 || (direction != 0 && math.Signbit(angle) != math.Signbit(direction)) {
+			return Arc{}, false
+		}
+		direction = angle
+		sweep += math.Abs(angle)
+	}
+
+	// don't allow a full circle, because the end point would be ambiguous
+	if sweep >= 2*math.Pi-0.01 {
+		return Arc{}, false
+	}
+
+	arc := Arc{
+		plane:     plane,
+		end:       pts[n-1],
+		clockwise: direction < 0,
+	}
+
+	// convert the centre back to an offset from the start point in X/Y/Z
+	du := ux - ax
+	dv := uy - ay
+	if plane == PlaneXY {
+		arc.i, arc.j = du, dv
+	} else if plane == PlaneZX {
+		arc.k, arc.i = du, dv
+	} else {
+		arc.j, arc.k = du, dv
+	}
+
+	return arc, true
+}
@@ -0,0 +1,66 @@
+package pngcam
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitArc(t *testing.T) {
+	// a hump in the XZ plane, traversed in +X: counter-clockwise when viewed
+	// from +Y, so G3 in G18
+	seg := NewToolpathSegment()
+	for x := -5.0; x <= 5.0; x += 0.5 {
+		seg.Append(Toolpoint{x, 2, math.Sqrt(100-x*x) - 10, CuttingFeed})
+	}
+
+	arc, end, ok := seg.FitArc(0, 0.01)
+	if !ok {
+		t.Fatalf("can't fit arc to points on a circle")
+	}
+	if end != len(seg.points)-1 {
+		t.Errorf("arc should cover all points, ended at %d", end)
+	}
+	if arc.plane != PlaneZX {
+		t.Errorf("arc should be in ZX plane, got %v", arc.plane)
+	}
+	if arc.clockwise {
+		t.Errorf("arc should be counter-clockwise")
+	}
+
+	start := seg.points[0]
+	checkFloat(t, "arc centre x", start.X+arc.i, 0)
+	checkFloat(t, "arc centre z", start.Z+arc.k, -10)
+	checkFloat(t, "arc j", arc.j, 0)
+
+	// the same points in reverse go clockwise
+	rev := seg.Reversed()
+	arc, _, ok = rev.FitArc(0, 0.01)
+	if !ok || !arc.clockwise {
+		t.Errorf("reversed arc should be clockwise")
+	}
+
+	// a straight line is not an arc
+	line := NewToolpathSegment()
+	for x := 0.0; x <= 5.0; x += 1 {
+		line.Append(Toolpoint{x, x, 0, CuttingFeed})
+	}
+	_, _, ok = line.FitArc(0, 0.01)
+	if ok {
+		t.Errorf("straight line should not fit an arc")
+	}
+
+	// a kink in the path is out of tolerance
+	seg.points[5].Z -= 0.1
+	_, end, _ = seg.FitArc(0, 0.01)
+	if end >= 5 {
+		t.Errorf("arc should stop before the out-of-tolerance point, ended at %d", end)
+	}
+}
+
+func checkFloat(t *testing.T, name string, got float64, want float64) {
+	epsilon := 0.00001
+
+	if math.Abs(got-want) > epsilon {
+		t.Errorf("%s should be %v, got %v", name, want, got)
+	}
+}
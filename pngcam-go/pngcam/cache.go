@@ -0,0 +1,161 @@
+package pngcam
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// toolpoints maps only depend on the heightmap and a few of the options, so
+// they can be kept in a cache directory and reused by later runs that only
+// change things like feed rates, step-over, or route. Whatever part of the
+// map has been worked out is cached, so a run with a different step-over
+// fills in the gaps and writes it back.
+
+const cacheMagic = "pngcam-toolpoints 1\n"
+
+// CacheKey identifies everything the cut depths depend on: the depth of every
+// pixel of the heightmap (which covers its content as well as --invert,
+// --normalise, and flipping), the tool, depth, stock to leave, --deep-black,
+// and the pixel scale
+func (m *ToolpointsMap) CacheKey() string {
+	opt := m.options
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s%#v\ndepth %g\nstock-to-leave %g\ndeep-black %v\nrotary %v\npx %dx%d\nmm/px %g %g\n",
+		cacheMagic, m.tool, opt.Depth, opt.StockToLeave, opt.CutBelowBottom, opt.Rotary, m.w, m.h, opt.x_MmPerPx, opt.y_MmPerPx)
+
+	// including one pixel outside the image, which is what CutDepth() sees
+	// beyond the edges
+	row := make([]float64, m.w+2)
+	for y := -1; y <= m.h; y++ {
+		for x := -1; x <= m.w; x++ {
+			row[x+1] = m.hm.GetDepthPx(x, y)
+		}
+		binary.Write(h, binary.LittleEndian, row)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (m *ToolpointsMap) cachePath(dir string) string {
+	if m.cacheKey == "" {
+		m.cacheKey = m.CacheKey()
+	}
+	return filepath.Join(dir, m.cacheKey+".toolpoints")
+}
+
+// ReadCache fills in the pixels of the map that were cached in dir, and
+// returns how many there were; it is not an error for there to be nothing
+// cached yet
+func (m *ToolpointsMap) ReadCache(dir string) (int, error) {
+	path := m.cachePath(dir)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(cacheMagic))
+	var w, h uint32
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != cacheMagic {
+		return 0, fmt.Errorf("%s: not a toolpoints cache file", path)
+	}
+	binary.Read(r, binary.LittleEndian, &w)
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	if int(w) != m.w || int(h) != m.h {
+		return 0, fmt.Errorf("%s: cached map is %dx%d px, expected %dx%d", path, w, h, m.w, m.h)
+	}
+
+	cached := make([]float64, m.w*m.h)
+	if err := binary.Read(r, binary.LittleEndian, cached); err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for i, z := range cached {
+		if !math.IsNaN(z) && math.IsNaN(m.height[i]) {
+			m.height[i] = z
+			n++
+		}
+	}
+
+	complete := true
+	for _, z := range m.height {
+		if math.IsNaN(z) {
+			complete = false
+			break
+		}
+	}
+	m.complete.Store(complete)
+
+	return n, nil
+}
+
+// WriteCache writes the map to dir, if any pixels have been worked out since
+// it was read from there, and returns how many pixels it wrote
+func (m *ToolpointsMap) WriteCache(dir string) (int, error) {
+	if m.computed.Load() == 0 {
+		return 0, nil
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	// write to a temporary file first, so that a run that is interrupted, or
+	// another one using the same cache, never sees half a file
+	path := m.cachePath(dir)
+	f, err := os.CreateTemp(dir, ".toolpoints-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	f.Chmod(0644)
+
+	m.mu.RLock()
+	n := 0
+	for _, z := range m.height {
+		if !math.IsNaN(z) {
+			n++
+		}
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(cacheMagic)
+	binary.Write(w, binary.LittleEndian, uint32(m.w))
+	binary.Write(w, binary.LittleEndian, uint32(m.h))
+	binary.Write(w, binary.LittleEndian, m.height)
+	err = w.Flush()
+	m.mu.RUnlock()
+
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return 0, err
+	}
+
+	m.computed.Store(0)
+
+	return n, nil
+}
@@ -0,0 +1,68 @@
+package pngcam
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToolpointsCache(t *testing.T) {
+	dir := t.TempDir()
+
+	newMap := func(tool string) *ToolpointsMap {
+		opt := benchmarkOptions()
+		opt.Tool, _ = NewTool(tool, 3)
+		return openCutDepthTest(t, &opt).ToToolpointsMap()
+	}
+
+	// only part of the map gets worked out, and nothing is written until some
+	// of it has been
+	first := newMap("ball")
+	if n, err := first.WriteCache(dir); n != 0 || err != nil {
+		t.Errorf("empty map shouldn't be cached, got %d, %v", n, err)
+	}
+	for x := 0; x < first.w; x++ {
+		first.GetPx(x, 100)
+	}
+	if n, err := first.WriteCache(dir); n != first.w || err != nil {
+		t.Errorf("should cache %d toolpoints, got %d, %v", first.w, n, err)
+	}
+
+	second := newMap("ball")
+	if n, err := second.ReadCache(dir); n != first.w || err != nil {
+		t.Errorf("should read %d toolpoints, got %d, %v", first.w, n, err)
+	}
+	for x := 0; x < second.w; x++ {
+		if second.height[100*second.w+x] != first.GetPx(x, 100) {
+			t.Fatalf("cached toolpoint at %v,100 should be %v, got %v", x, first.GetPx(x, 100), second.height[100*second.w+x])
+		}
+	}
+	if !math.IsNaN(second.height[0]) {
+		t.Errorf("toolpoints that weren't cached should still need computing")
+	}
+	if second.computed.Load() != 0 {
+		t.Errorf("reading the cache shouldn't count as computing anything")
+	}
+
+	// a different tool gets its own cache entry
+	other := newMap("flat")
+	if other.CacheKey() == first.CacheKey() {
+		t.Errorf("different tools should have different cache keys")
+	}
+	if n, err := other.ReadCache(dir); n != 0 || err != nil {
+		t.Errorf("nothing should be cached for a different tool, got %d, %v", n, err)
+	}
+
+	// a broken cache file is an error, but doesn't stop the map working
+	err := os.WriteFile(filepath.Join(dir, other.CacheKey()+".toolpoints"), []byte("garbage"), 0644)
+	if err != nil {
+		t.Fatalf("can't write file: %v", err)
+	}
+	if _, err := other.ReadCache(dir); err == nil {
+		t.Errorf("broken cache file should be an error")
+	}
+	if math.IsNaN(other.GetPx(0, 0)) {
+		t.Errorf("map should still work without the cache")
+	}
+}
@@ -0,0 +1,142 @@
+package pngcam
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CutDepth() only looks at the cutting part of the tool, but above the
+// flutes is the shank, and above that is the holder, and in deep narrow
+// pockets either of them can hit the part. They are both cylinders, so the
+// lowest the tip can go without one of them touching the part is worked out
+// exactly like the cut depth of a flat end mill of the same diameter, less
+// its height above the tip. Only the parts that are wider than everything
+// below them are checked, because CutDepth() already treats the tool as
+// going straight up from its widest point.
+
+type holderPart struct {
+	name string
+	// cut depths of a flat end mill the size of the part
+	depth *ToolpointsMap
+	// height of the bottom of the part above the tip
+	height float64
+}
+
+// Collision is a toolpoint where the shank or holder would hit the part if
+// the tool went down to the cut depth
+type Collision struct {
+	X    float64
+	Y    float64
+	Z    float64 // lowest the tip can go without hitting the part
+	Lift float64 // how far that is above the cut depth
+	Part string  // "shank" or "holder"
+}
+
+// holderParts returns the parts of the tool above the cutter that need to be
+// kept clear of the part, from FluteLength, ShankDiameter, StickOut, and
+// HolderDiameter
+func (hm *HeightmapImage) holderParts() ([]holderPart, error) {
+	opt := hm.options
+
+	if opt.FluteLength < 0 || opt.ShankDiameter < 0 || opt.StickOut < 0 || opt.HolderDiameter < 0 {
+		return nil, fmt.Errorf("flute length, shank diameter, stick-out, and holder diameter can't be negative")
+	}
+	if opt.StickOut > 0 && opt.StickOut < opt.FluteLength {
+		return nil, fmt.Errorf("stick-out %g can't be less than the flute length %g", opt.StickOut, opt.FluteLength)
+	}
+	if opt.ShankDiameter > 0 && opt.FluteLength == 0 {
+		fmt.Fprintf(os.Stderr, "warning: not checking the shank for collisions without a flute length\n")
+	}
+	if opt.HolderDiameter > 0 && opt.StickOut == 0 {
+		fmt.Fprintf(os.Stderr, "warning: not checking the holder for collisions without a stick-out\n")
+	}
+
+	parts := []holderPart{}
+	radius := opt.Tool.Radius()
+
+	add := func(name string, diameter float64, height float64) {
+		if height == 0 || diameter/2 <= radius {
+			return
+		}
+		radius = diameter / 2
+
+		// the top of the part is never more than Depth plus the tool
+		// radius above the tip
+		if height >= opt.Depth+opt.Tool.Radius() {
+			return
+		}
+
+		depth := hm.ToToolpointsMap()
+		depth.tool = &FlatEndMill{radius: diameter / 2}
+		parts = append(parts, holderPart{name: name, depth: depth, height: height})
+	}
+	add("shank", opt.ShankDiameter, opt.FluteLength)
+	add("holder", opt.HolderDiameter, opt.StickOut)
+
+	return parts, nil
+}
+
+// HolderLiftMm returns how far the toolpoint at (x, y) has been lifted above
+// the cut depth to keep the shank or holder clear of the part, and which one
+// it was, or 0 if it hasn't been
+func (m *ToolpointsMap) HolderLiftMm(x, y float64) (float64, string) {
+	px, py := m.options.MmToPx(x, y)
+
+	z := m.cutDepthPx(px, py)
+	lift := 0.0
+	name := ""
+	for _, part := range m.holder {
+		if l := part.depth.GetPx(px, py) - part.height - z; l > lift {
+			lift = l
+			name = part.name
+		}
+	}
+
+	// ignore floating point noise
+	if lift < 0.00001 {
+		return 0, ""
+	}
+	return lift, name
+}
+
+// addCollision records a collision, unless there has already been one at the
+// same pixel, e.g. from another route
+func (j *Job) addCollision(c Collision) {
+	px, py := j.options.MmToPx(c.X, c.Y)
+	key := [2]int{px, py}
+	if j.collided[key] {
+		return
+	}
+	if j.collided == nil {
+		j.collided = map[[2]int]bool{}
+	}
+	j.collided[key] = true
+	j.collisions = append(j.collisions, c)
+}
+
+// Collisions returns the toolpoints that have been lifted to keep the shank
+// and holder clear of the part
+func (j *Job) Collisions() []Collision {
+	return j.collisions
+}
+
+// collisionReport describes where the shank or holder hit the part, in G-code
+// coordinates, for the error with FailOnCollision
+func (j *Job) collisionReport() string {
+	opt := j.options
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "shank or holder would hit the part at %d toolpoints:", len(j.collisions))
+
+	const maxListed = 20
+	for i, c := range j.collisions {
+		if i == maxListed {
+			fmt.Fprintf(&b, "\n  ... and %d more", len(j.collisions)-maxListed)
+			break
+		}
+		fmt.Fprintf(&b, "\n  %s at X%.3f %s%.3f: tip needs to be at Z%.3f, %.3f above the cut depth", c.Part, c.X+opt.XOffset, yAxisName(*opt), c.Y+opt.YOffset, c.Z+opt.ZOffset, c.Lift)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,195 @@
+package pngcam
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShankCollision(t *testing.T) {
+	// a 10 mm square, 8 mm deep, with a 2 mm wide slot down the middle
+	w, h := 40, 40
+	heights := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			heights[y*w+x] = 8
+			if x >= 16 && x < 24 {
+				heights[y*w+x] = 0
+			}
+		}
+	}
+
+	deepest := func(set func(opt *Options)) (float64, *Job, error) {
+		opt := DefaultOptions()
+		opt.Width = 10
+		opt.Depth = 8
+		opt.StepOver = 0.5
+		opt.Quiet = true
+		opt.Tool, _ = NewTool("flat", 1)
+		set(&opt)
+
+		job, err := NewJobFromHeights(w, h, heights, &opt)
+		if err != nil {
+			return 0, nil, err
+		}
+		minZ := 0.0
+		for _, seg := range job.Toolpath().Segments() {
+			for _, p := range seg.Points() {
+				if p.Z < minZ {
+					minZ = p.Z
+				}
+			}
+		}
+		return minZ, job, nil
+	}
+
+	// long enough flutes to reach the bottom
+	minZ, job, err := deepest(func(opt *Options) {
+		opt.FluteLength = 10
+		opt.ShankDiameter = 6
+	})
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	checkFloat(t, "deepest cut with long flutes", minZ, -8)
+	if len(job.Collisions()) != 0 {
+		t.Errorf("long flutes shouldn't collide, got %v", job.Collisions()[0])
+	}
+
+	// the shank can't go into the slot
+	minZ, job, err = deepest(func(opt *Options) {
+		opt.FluteLength = 3
+		opt.ShankDiameter = 6
+	})
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	checkFloat(t, "deepest cut with short flutes", minZ, -3)
+	if len(job.Collisions()) == 0 || job.Collisions()[0].Part != "shank" {
+		t.Errorf("short flutes should lift the tool clear of the shank")
+	}
+	for _, c := range job.Collisions() {
+		if c.Lift <= 0 || c.Lift > 5.00001 {
+			t.Errorf("lift at %v,%v should be up to 5, got %v", c.X, c.Y, c.Lift)
+		}
+	}
+
+	// each toolpoint only counts once, however many routes cross it
+	_, both, err := deepest(func(opt *Options) {
+		opt.FluteLength = 3
+		opt.ShankDiameter = 6
+		opt.Directions = []Direction{Horizontal, Vertical}
+	})
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	seen := map[[2]float64]bool{}
+	for _, c := range both.Collisions() {
+		if seen[[2]float64{c.X, c.Y}] {
+			t.Errorf("collision at %v,%v counted twice", c.X, c.Y)
+		}
+		seen[[2]float64{c.X, c.Y}] = true
+	}
+	if len(both.Collisions()) <= len(job.Collisions()) {
+		t.Errorf("vertical route should find more collisions, got %d vs %d", len(both.Collisions()), len(job.Collisions()))
+	}
+	n := len(both.Collisions())
+	both.MakeToolpath(Horizontal)
+	if len(both.Collisions()) != n {
+		t.Errorf("making the toolpath again shouldn't add collisions, got %d instead of %d", len(both.Collisions()), n)
+	}
+
+	// a shank no wider than the cutter can't hit anything
+	minZ, _, err = deepest(func(opt *Options) {
+		opt.FluteLength = 3
+		opt.ShankDiameter = 1
+	})
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	checkFloat(t, "deepest cut with narrow shank", minZ, -8)
+
+	// the holder
+	minZ, job, err = deepest(func(opt *Options) {
+		opt.FluteLength = 3
+		opt.StickOut = 5
+		opt.HolderDiameter = 20
+	})
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	checkFloat(t, "deepest cut with short stick-out", minZ, -5)
+	if len(job.Collisions()) == 0 || job.Collisions()[0].Part != "holder" {
+		t.Errorf("short stick-out should lift the tool clear of the holder")
+	}
+
+	_, _, err = deepest(func(opt *Options) {
+		opt.FluteLength = 3
+		opt.ShankDiameter = 6
+		opt.FailOnCollision = true
+	})
+	if err == nil || !strings.Contains(err.Error(), "shank at X") {
+		t.Errorf("collision should fail with a report, got %v", err)
+	}
+
+	_, _, err = deepest(func(opt *Options) {
+		opt.FluteLength = 5
+		opt.StickOut = 3
+		opt.HolderDiameter = 20
+	})
+	if err == nil {
+		t.Errorf("stick-out shorter than the flutes should be an error")
+	}
+}
+
+func TestShankCollisionRotary(t *testing.T) {
+	// 20 mm diameter, with a slot 8 mm deep and 20 degrees wide all along
+	w, h := 10, 72
+	heights := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			heights[y*w+x] = 10
+			if y >= 34 && y < 38 {
+				heights[y*w+x] = 2
+			}
+		}
+	}
+
+	deepest := func(fluteLength float64) (float64, int) {
+		opt := DefaultOptions()
+		opt.Rotary = true
+		opt.Width = 10
+		opt.Depth = 10
+		opt.StepOver = 2
+		opt.Quiet = true
+		opt.Tool, _ = NewTool("flat", 1)
+		opt.FluteLength = fluteLength
+		opt.ShankDiameter = 6
+
+		job, err := NewJobFromHeights(w, h, heights, &opt)
+		if err != nil {
+			t.Fatalf("can't create job: %v", err)
+		}
+		minZ := opt.Depth
+		route := job.MakeToolpath(opt.Directions[0])
+		for _, seg := range route.Segments() {
+			for _, p := range seg.Points() {
+				if p.Z < minZ {
+					minZ = p.Z
+				}
+			}
+		}
+		return minZ, len(job.Collisions())
+	}
+
+	minZ, n := deepest(10)
+	if minZ > 2.5 || n != 0 {
+		t.Errorf("long flutes should reach the bottom of the slot, got %v with %d collisions", minZ, n)
+	}
+
+	// the sides of the slot at 10 degrees are 1.7 mm from the tool's axis,
+	// and 9.8 mm up, so the bottom of the shank can't go below there
+	minZ, n = deepest(3)
+	if minZ < 9.8-3 || n == 0 {
+		t.Errorf("short flutes should lift the tool clear of the shank, got %v with %d collisions", minZ, n)
+	}
+}
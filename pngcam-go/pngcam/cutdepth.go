@@ -0,0 +1,248 @@
+package pngcam
+
+import (
+	"math"
+)
+
+// cutDepthRows works out CutDepth() for a whole row of pixels at a time, for
+// non-rotary jobs, giving exactly the same answers but much more quickly.
+//
+// CutDepth() looks at every heightmap pixel under the tool for every
+// toolpoint. Here the samples are grouped by their Y offset from the tool
+// centre: for each of these rows of samples we run a 1D max-filter along the
+// heightmap row they land on, which gives an upper bound on the cut depth
+// from that row in O(1) per pixel. The rows nearest the centre of the tool
+// are checked in full first, and the rest of the rows can usually be skipped
+// because their bound shows that they can't make any difference.
+//
+// The sample positions are exactly the ones CutDepth() uses (including its
+// floating point rounding), so the results are identical.
+type cutDepthRows struct {
+	hm *HeightmapImage
+	w  int
+
+	// heightmap depths, padded to cover every pixel the tool can reach
+	// from inside the image, and -Inf for pixels that don't count because
+	// of --deep-black
+	depth      []float64
+	depthW     int
+	minX, minY int
+
+	// per sample column k and pixel x, the heightmap column that is read
+	col [][]int
+	// one for each row of samples, in the order to check them
+	rows []sampleRow
+
+	belowBottomDepth float64
+}
+
+type sampleRow struct {
+	// per pixel y, the heightmap row that is read
+	row []int
+	// sample columns within the tool radius, with StockToLeave minus the
+	// height of the tool at each one
+	k      []int
+	offset []float64
+	// largest of offset
+	maxOffset float64
+	// range of heightmap columns the samples can land on, relative to x
+	lo, hi int
+}
+
+func (hm *HeightmapImage) newCutDepthRows(tool Tool) *cutDepthRows {
+	opt := hm.options
+
+	w := hm.img.Bounds().Max.X
+	h := hm.img.Bounds().Max.Y
+
+	c := &cutDepthRows{
+		hm:               hm,
+		w:                w,
+		belowBottomDepth: -opt.Depth - tool.Radius() + opt.StockToLeave,
+	}
+
+	// the same sample positions as CutDepth()
+	sxs := []float64{}
+	for sx := -tool.Radius(); sx <= tool.Radius(); sx += opt.x_MmPerPx {
+		sxs = append(sxs, sx)
+	}
+	sys := []float64{}
+	for sy := -tool.Radius(); sy <= tool.Radius(); sy += opt.y_MmPerPx {
+		sys = append(sys, sy)
+	}
+
+	c.minX, c.minY = math.MaxInt, math.MaxInt
+	maxX, maxY := math.MinInt, math.MinInt
+
+	c.col = make([][]int, len(sxs))
+	for k, sx := range sxs {
+		c.col[k] = make([]int, w)
+		for x := 0; x < w; x++ {
+			xMm, yMm := opt.PxToMm(x, 0)
+			c.col[k][x], _ = opt.MmToPx(xMm+sx, yMm)
+		}
+	}
+
+	toolRadiusSqr := tool.Radius() * tool.Radius()
+
+	for _, sy := range sys {
+		r := sampleRow{
+			row:       make([]int, h),
+			maxOffset: math.Inf(-1),
+			lo:        math.MaxInt,
+			hi:        math.MinInt,
+		}
+
+		for k, sx := range sxs {
+			rSqr := sx*sx + sy*sy
+			if rSqr > toolRadiusSqr {
+				continue
+			}
+			offset := opt.StockToLeave - tool.HeightAtRadiusSqr(rSqr)
+			r.k = append(r.k, k)
+			r.offset = append(r.offset, offset)
+			if offset > r.maxOffset {
+				r.maxOffset = offset
+			}
+
+			for x := 0; x < w; x++ {
+				if c.col[k][x]-x < r.lo {
+					r.lo = c.col[k][x] - x
+				}
+				if c.col[k][x]-x > r.hi {
+					r.hi = c.col[k][x] - x
+				}
+			}
+		}
+		if len(r.k) == 0 {
+			continue
+		}
+
+		for y := 0; y < h; y++ {
+			xMm, yMm := opt.PxToMm(0, y)
+			_, r.row[y] = opt.MmToPx(xMm, yMm+sy)
+			if r.row[y] < c.minY {
+				c.minY = r.row[y]
+			}
+			if r.row[y] > maxY {
+				maxY = r.row[y]
+			}
+		}
+
+		// the max-filter reads the whole window for every x
+		if r.lo < c.minX {
+			c.minX = r.lo
+		}
+		if w-1+r.hi > maxX {
+			maxX = w - 1 + r.hi
+		}
+
+		c.rows = append(c.rows, r)
+	}
+
+	// the rows nearer the middle of the tool are more likely to decide the
+	// depth, so check them first
+	for i := 1; i < len(c.rows); i++ {
+		for j := i; j > 0 && c.rows[j].maxOffset > c.rows[j-1].maxOffset; j-- {
+			c.rows[j], c.rows[j-1] = c.rows[j-1], c.rows[j]
+		}
+	}
+
+	if len(c.rows) == 0 {
+		return c
+	}
+
+	c.depthW = maxX - c.minX + 1
+	c.depth = make([]float64, c.depthW*(maxY-c.minY+1))
+	for y := c.minY; y <= maxY; y++ {
+		for x := c.minX; x <= maxX; x++ {
+			d := hm.GetDepthPx(x, y)
+			if opt.CutBelowBottom && d < -opt.Depth+0.00001 {
+				// see IsBottom()
+				d = math.Inf(-1)
+			}
+			c.depth[(y-c.minY)*c.depthW+x-c.minX] = d
+		}
+	}
+
+	return c
+}
+
+// rowBuffers is the scratch space needed by Row(), which can't be shared
+// between goroutines
+type rowBuffers struct {
+	bound [][]float64
+	g, h  []float64
+}
+
+func (c *cutDepthRows) newRowBuffers() *rowBuffers {
+	b := &rowBuffers{
+		bound: make([][]float64, len(c.rows)),
+		g:     make([]float64, c.depthW),
+		h:     make([]float64, c.depthW),
+	}
+	for i := range b.bound {
+		b.bound[i] = make([]float64, c.w)
+	}
+	return b
+}
+
+// Row fills out with the cut depth of each pixel in row y
+func (c *cutDepthRows) Row(y int, out []float64, buf *rowBuffers) {
+	for i := range c.rows {
+		r := &c.rows[i]
+		depth := c.depth[(r.row[y]-c.minY)*c.depthW : (r.row[y]-c.minY+1)*c.depthW]
+		windowMax(depth[r.lo-c.minX:], r.hi-r.lo+1, buf.bound[i], buf.g, buf.h)
+	}
+
+	for x := 0; x < c.w; x++ {
+		maxDepth := c.belowBottomDepth
+
+		for i := range c.rows {
+			r := &c.rows[i]
+
+			// nothing in this row can cut deeper than we already have
+			if buf.bound[i][x]+r.maxOffset <= maxDepth {
+				continue
+			}
+
+			row := (r.row[y] - c.minY) * c.depthW
+			for j, k := range r.k {
+				d := r.offset[j] + c.depth[row+c.col[k][x]-c.minX]
+				if d > maxDepth {
+					maxDepth = d
+				}
+			}
+		}
+
+		out[x] = maxDepth
+	}
+}
+
+// windowMax sets dst[i] to the largest of src[i:i+n], using the van Herk/Gil-
+// Werman algorithm: within each block of n, g holds the running max from the
+// start of the block and h the running max to the end of it, so any window is
+// covered by one value of each
+func windowMax(src []float64, n int, dst []float64, g []float64, h []float64) {
+	m := len(dst) + n - 1
+
+	for i := 0; i < m; i++ {
+		g[i] = src[i]
+		if i%n != 0 && g[i-1] > g[i] {
+			g[i] = g[i-1]
+		}
+	}
+	for i := m - 1; i >= 0; i-- {
+		h[i] = src[i]
+		if i != m-1 && (i+1)%n != 0 && h[i+1] > h[i] {
+			h[i] = h[i+1]
+		}
+	}
+
+	for i := range dst {
+		dst[i] = h[i]
+		if g[i+n-1] > dst[i] {
+			dst[i] = g[i+n-1]
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pngcam
+
+import (
+	"testing"
+)
+
+func openCutDepthTest(t testing.TB, opt *Options) *HeightmapImage {
+	opt.HeightmapPath = "../../t/data/klingon-dagger.png"
+	opt.Quiet = true
+	job, err := NewJob(opt)
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	return job.toolpoints.hm
+}
+
+func TestCutDepthRows(t *testing.T) {
+	configs := []struct {
+		name string
+		set  func(opt *Options)
+	}{
+		// tool radius is a whole number of pixels, so CutDepth()'s samples
+		// land right on the pixel boundaries
+		{"ball", func(opt *Options) { opt.Width = 23.2; opt.Tool, _ = NewTool("ball", 3) }},
+		{"flat", func(opt *Options) { opt.Width = 40; opt.Tool, _ = NewTool("flat", 5) }},
+		{"vbit", func(opt *Options) {
+			opt.Width = 30
+			opt.Tool, _ = NewTool("vbit60", 4)
+			opt.CutBelowBottom = true
+			opt.StockToLeave = 0.3
+			opt.XFlip = true
+			opt.Invert = true
+		}},
+		{"non-square", func(opt *Options) {
+			opt.Width = 50
+			opt.Height = 100
+			opt.Tool, _ = NewTool("ball", 6.35)
+			opt.Normalise = true
+		}},
+	}
+
+	for _, config := range configs {
+		opt := DefaultOptions()
+		config.set(&opt)
+		hm := openCutDepthTest(t, &opt)
+
+		rows := hm.newCutDepthRows(opt.Tool)
+		buf := rows.newRowBuffers()
+		rowDepth := make([]float64, opt.widthPx)
+
+		for y := 0; y < opt.heightPx; y += 13 {
+			rows.Row(y, rowDepth, buf)
+			for x := 0; x < opt.widthPx; x++ {
+				want := hm.CutDepth(opt.PxToMm(x, y))
+				if rowDepth[x] != want {
+					t.Fatalf("%s: cut depth at %v,%v should be %v, got %v", config.name, x, y, want, rowDepth[x])
+				}
+			}
+		}
+	}
+}
+
+func TestWindowMax(t *testing.T) {
+	src := []float64{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5}
+	for n := 1; n <= len(src); n++ {
+		dst := make([]float64, len(src)-n+1)
+		windowMax(src, n, dst, make([]float64, len(src)), make([]float64, len(src)))
+		for i := range dst {
+			want := src[i]
+			for _, v := range src[i : i+n] {
+				if v > want {
+					want = v
+				}
+			}
+			if dst[i] != want {
+				t.Errorf("max of %v should be %v, got %v", src[i:i+n], want, dst[i])
+			}
+		}
+	}
+}
+
+func benchmarkOptions() Options {
+	opt := DefaultOptions()
+	opt.Width = 23.2
+	opt.Tool, _ = NewTool("ball", 3)
+	return opt
+}
+
+func BenchmarkCutDepth(b *testing.B) {
+	opt := benchmarkOptions()
+	hm := openCutDepthTest(b, &opt)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for y := 0; y < opt.heightPx; y++ {
+			for x := 0; x < opt.widthPx; x++ {
+				hm.CutDepth(opt.PxToMm(x, y))
+			}
+		}
+	}
+}
+
+func BenchmarkCutDepthRows(b *testing.B) {
+	opt := benchmarkOptions()
+	hm := openCutDepthTest(b, &opt)
+	rowDepth := make([]float64, opt.widthPx)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rows := hm.newCutDepthRows(opt.Tool)
+		buf := rows.newRowBuffers()
+		for y := 0; y < opt.heightPx; y++ {
+			rows.Row(y, rowDepth, buf)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Package pngcam generates G-code toolpaths for carving heightmaps on a CNC
+// milling machine, in 3 axes, or in 4 axes with a rotary axis.
+//
+// A heightmap is an image whose brightness gives the height of the part
+// (white is the top of the stock, and black is Depth below it), or a
+// FloatImage of heights in mm. To cut one:
+//
+//	opt := pngcam.DefaultOptions()
+//	opt.Width = 50
+//	opt.Depth = 5
+//	opt.Tool, _ = pngcam.NewTool("ball", 3)
+//
+//	job, err := pngcam.NewJobFromImage(img, &opt)
+//	if err != nil {
+//		return err
+//	}
+//	return job.WriteGcode(w)
+//
+// WriteGcode() streams the program to w as it goes, rather than building it
+// in memory first, and Job.CycleTime() then gives the estimated time to run
+// it. Job.Toolpath() gives the toolpath without turning it into G-code, and a
+// GcodeWriter can write other toolpaths in the same way.
+package pngcam
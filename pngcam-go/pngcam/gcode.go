@@ -0,0 +1,186 @@
+package pngcam
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strings"
+)
+
+// GcodeWriter writes toolpoints out as G-code as they are given to it, so
+// that the program as a whole never has to be held in memory, and plans the
+// machine's motion as it goes, so that the cycle time is known at the end
+type GcodeWriter struct {
+	w       *bufio.Writer
+	opt     Options
+	planner *motionPlanner
+
+	prev    Toolpoint
+	started bool
+	plane   Plane
+
+	// the end of the segment that AppendPoint() is building, held back until
+	// no arc could go on past it; the first point has already been written,
+	// unless the segment has only just been started
+	open    bool
+	pending ToolpathSegment
+	from    int
+}
+
+func NewGcodeWriter(w io.Writer, opt Options) *GcodeWriter {
+	return &GcodeWriter{
+		w:       bufio.NewWriter(w),
+		opt:     opt,
+		planner: newMotionPlanner(opt),
+		plane:   Plane(-1),
+	}
+}
+
+// WriteString writes s unchanged, e.g. for the preamble and postamble
+func (g *GcodeWriter) WriteString(s string) {
+	g.w.WriteString(s)
+}
+
+// WriteToolpath writes each segment of tp in turn, with rapid moves up to
+// safe Z and back down in between
+func (g *GcodeWriter) WriteToolpath(tp *Toolpath) {
+	for i := range tp.segments {
+		seg := &tp.segments[i]
+		if len(seg.points) == 0 {
+			continue
+		}
+
+		for _, p := range approach(seg, g.opt) {
+			g.writePoint(p)
+		}
+		g.WriteSegment(seg)
+		g.writePoint(retract(seg, g.opt))
+	}
+}
+
+// AppendPoint adds p to the end of a segment that is written out a point at a
+// time, for segments too big to hold in memory; the G-code is the same as
+// WriteToolpath() gives for a toolpath of just that segment, once EndSegment()
+// has been called
+func (g *GcodeWriter) AppendPoint(p Toolpoint) {
+	if !g.open {
+		g.pending = NewToolpathSegment()
+		g.pending.Append(p)
+		g.from = 0
+		g.open = true
+		for _, a := range approach(&g.pending, g.opt) {
+			g.writePoint(a)
+		}
+	} else {
+		g.pending.Append(p)
+	}
+
+	// arcs only ever join up cutting moves
+	if p.Feed != CuttingFeed {
+		g.writeSegment(&g.pending, g.from)
+		g.pending = NewToolpathSegment()
+		g.pending.Append(p)
+		g.from = 1
+	}
+}
+
+// EndSegment finishes the segment from AppendPoint() with a rapid up to safe Z
+func (g *GcodeWriter) EndSegment() {
+	if !g.open {
+		return
+	}
+	g.writeSegment(&g.pending, g.from)
+	g.writePoint(retract(&g.pending, g.opt))
+	g.pending = NewToolpathSegment()
+	g.open = false
+}
+
+// WriteSegment writes a move to each point of seg, replacing runs of points
+// with arcs if ArcTolerance is set; the first move of the program is a rapid
+func (g *GcodeWriter) WriteSegment(seg *ToolpathSegment) {
+	g.writeSegment(seg, 0)
+}
+
+// writeSegment is WriteSegment() from seg.points[from], for when the points
+// before it have already been written
+func (g *GcodeWriter) writeSegment(seg *ToolpathSegment, from int) {
+	opt := g.opt
+
+	for i := from; i < len(seg.points); i++ {
+		p := seg.points[i]
+
+		// TODO: support arcs in rotary mode? they would only be valid in the
+		// plane perpendicular to the rotary axis
+		if i > 0 && g.started && p.Feed != RapidFeed && opt.ArcTolerance > 0 && !opt.Rotary {
+			arc, end, ok := seg.FitArc(i-1, opt.ArcTolerance)
+			if ok {
+				if arc.plane != g.plane {
+					g.w.WriteString(opt.Post.Plane(arc.plane))
+					g.plane = arc.plane
+				}
+
+				// use the slowest feed rate of any of the lines that the arc replaces
+				feedRate := math.Inf(1)
+				for k := i; k <= end; k++ {
+					feedRate = math.Min(feedRate, opt.FeedRate(seg.points[k-1], seg.points[k]))
+				}
+
+				g.w.WriteString(opt.Post.Arc(opt, arc, feedRate))
+
+				// the cycle time still assumes straight lines
+				for k := i; k <= end; k++ {
+					g.moved(seg.points[k])
+				}
+				i = end
+				continue
+			}
+		}
+
+		g.writePoint(p)
+	}
+}
+
+// move in a straight line from the previous point to p
+func (g *GcodeWriter) writePoint(p Toolpoint) {
+	if p.Feed == RapidFeed || !g.started {
+		// G0 moves ignore the feed rate (and therefore the G93/G94 mode)
+		g.w.WriteString(g.opt.Post.Rapid(g.opt, p))
+	} else {
+		g.w.WriteString(g.opt.Post.Move(g.opt, p, g.opt.FeedRate(g.prev, p)))
+	}
+	g.moved(p)
+}
+
+func (g *GcodeWriter) moved(p Toolpoint) {
+	g.planner.Add(p)
+	g.prev = p
+	g.started = true
+}
+
+// Flush writes out anything still buffered, and returns the first error
+// encountered while writing
+func (g *GcodeWriter) Flush() error {
+	return g.w.Flush()
+}
+
+// CycleTime returns the estimated time in seconds to run all of the moves
+// written so far
+func (g *GcodeWriter) CycleTime() float64 {
+	return g.planner.Time()
+}
+
+func (seg *ToolpathSegment) ToGcode(opt Options) string {
+	gcode := strings.Builder{}
+	g := NewGcodeWriter(&gcode, opt)
+	g.WriteSegment(seg)
+	g.Flush()
+	return gcode.String()
+}
+
+func (tp *Toolpath) ToGcode(opt Options) string {
+	gcode := strings.Builder{}
+	g := NewGcodeWriter(&gcode, opt)
+	g.WriteToolpath(tp)
+	g.Flush()
+	return gcode.String()
+}
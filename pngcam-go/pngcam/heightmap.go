@@ -0,0 +1,534 @@
+package pngcam
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+type HeightmapImage struct {
+	img     image.Image
+	options *Options
+
+	// description of the part, if the file format has one (see HeightmapFormat)
+	metadata map[string]string
+
+	// brightness range for normalisation, filled in by ScanBrightness()
+	normalised    bool
+	minBrightness float64
+	maxBrightness float64
+}
+
+type ToolpointsMap struct {
+	w             int
+	h             int
+	hm            *HeightmapImage
+	height        []float64
+	initialHeight float64
+	options       *Options
+	// tool whose cut depths come from hm; usually options.Tool
+	tool Tool
+
+	// parts of the tool above the cutter that the toolpoints are lifted to
+	// keep clear of the part (see collision.go)
+	holder []holderPart
+
+	// guards height while it is being filled in lazily from hm, so that
+	// GetPx() can be called from more than one goroutine
+	mu       sync.RWMutex
+	complete atomic.Bool // every pixel has been filled in by Precompute()
+	// pixels filled in from hm since the map was last read from or written
+	// to the cache
+	computed atomic.Int64
+	cacheKey string
+}
+
+// OpenHeightmapImage reads a heightmap in any of the formats supported by
+// HeightmapFormatFor()
+func OpenHeightmapImage(path string, opt *Options) (*HeightmapImage, error) {
+	img, metadata, err := HeightmapFormatFor(path, opt).Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HeightmapImage{
+		img:      img,
+		options:  opt,
+		metadata: metadata,
+	}, nil
+}
+
+func (hm *HeightmapImage) ToToolpointsMap() *ToolpointsMap {
+	opt := hm.options
+
+	w := hm.img.Bounds().Max.X
+	h := hm.img.Bounds().Max.Y
+
+	tpm := NewToolpointsMap(w, h, opt, math.NaN())
+	tpm.hm = hm
+	tpm.tool = opt.Tool
+
+	return tpm
+}
+
+// CutDepth returns the lowest the tip of the tool can go at (x, y) without
+// cutting into the part
+func (hm *HeightmapImage) CutDepth(x, y float64) float64 {
+	return hm.cutDepth(hm.options.Tool, x, y)
+}
+
+func (hm *HeightmapImage) cutDepth(tool Tool, x, y float64) float64 {
+	opt := hm.options
+
+	belowBottomDepth := -opt.Depth - tool.Radius() + opt.StockToLeave
+
+	maxDepth := belowBottomDepth
+
+	toolRadiusSqr := tool.Radius() * tool.Radius()
+
+	if opt.Rotary {
+		for sy := -90.0; sy <= 90.0; sy += opt.y_MmPerPx { // we pretend the y range of 360 degrees is 360 "millimetres"
+			for sx := -tool.Radius(); sx <= tool.Radius(); sx += opt.x_MmPerPx {
+				workpieceZ := opt.Depth + hm.GetDepth(x+sx, -1-y+sy) // -y because the heightmap y axis is inverted (?) (but why -1 degree?)
+				realY := workpieceZ * math.Sin(sy*math.Pi/180.0)
+				realZ := workpieceZ * math.Cos(sy*math.Pi/180.0)
+
+				rSqr := sx*sx + realY*realY
+				if rSqr > toolRadiusSqr {
+					continue
+				}
+
+				// TODO: what about if !opt.cutBelowBottom || !hm.IsBottom(x+sx, ...) ?
+
+				d := opt.StockToLeave - tool.HeightAtRadiusSqr(rSqr) + realZ
+				if d > maxDepth {
+					maxDepth = d
+				}
+			}
+		}
+	} else {
+		for sy := -tool.Radius(); sy <= tool.Radius(); sy += opt.y_MmPerPx {
+			for sx := -tool.Radius(); sx <= tool.Radius(); sx += opt.x_MmPerPx {
+				rSqr := sx*sx + sy*sy
+				if rSqr > toolRadiusSqr {
+					continue
+				}
+
+				if !opt.CutBelowBottom || !hm.IsBottom(x+sx, y+sy) {
+					d := opt.StockToLeave - tool.HeightAtRadiusSqr(rSqr) + hm.GetDepth(x+sx, y+sy)
+					if d > maxDepth {
+						maxDepth = d
+					}
+				}
+			}
+		}
+	}
+
+	return maxDepth
+}
+
+func (hm *HeightmapImage) GetDepth(x, y float64) float64 {
+	opt := hm.options
+
+	px, py := opt.MmToPx(x, y)
+
+	return hm.GetDepthPx(px, py)
+}
+
+func (hm *HeightmapImage) GetDepthPx(px, py int) float64 {
+	opt := hm.options
+
+	if opt.Rotary {
+		// rotary parts wrap around
+		py = ((py % opt.heightPx) + opt.heightPx) % opt.heightPx // https://stackoverflow.com/a/59299881
+	}
+
+	px, py = opt.FlipPx(px, py)
+
+	brightness := hm.Brightness(px, py)
+
+	// normalisation is applied before inversion, like in the Perl version
+	if hm.normalised && (!opt.NormaliseIgnoreBlack || brightness != 0) {
+		brightness = (brightness - hm.minBrightness) / (hm.maxBrightness - hm.minBrightness)
+	}
+
+	if opt.Invert {
+		brightness = 1 - brightness
+	}
+
+	// float heightmaps can go outside the stock
+	brightness = math.Max(0, math.Min(1, brightness))
+
+	return brightness*opt.Depth - opt.Depth
+}
+
+// Brightness returns the brightness of the image pixel at (px,py), from 0 to 1,
+// without any flipping, normalisation, or inversion. Float heightmaps give
+// their height as a fraction of the depth, which may be outside 0 to 1.
+// 16-bit greyscale images are read at full precision. For colour images, R,
+// G, and B are packed into a single value with R as the most significant part
+// (24 bits for 8-bit images, 48 bits for 16-bit images), so greyscale colour
+// images come out the same as if they were stored as greyscale.
+func (hm *HeightmapImage) Brightness(px, py int) float64 {
+	switch img := hm.img.(type) {
+	case *FloatImage:
+		return img.Height(px, py) / hm.options.Depth
+	case *image.Gray16:
+		return float64(img.Gray16At(px, py).Y) / 65535
+	case *image.RGBA64, *image.NRGBA64:
+		r, g, b, _ := img.At(px, py).RGBA()
+		return (float64(r)*4294967296 + float64(g)*65536 + float64(b)) / 281474976710655
+	}
+
+	r, g, b, _ := hm.img.At(px, py).RGBA()
+	// XXX: why 257? https://stackoverflow.com/a/41185404 but doesn't really
+	// explain - empirically it doesn't make any difference whether it is 256 or
+	// 257, presumably it rounds to the same result
+	r /= 257
+	g /= 257
+	b /= 257
+	return float64(65536*r+256*g+b) / 16777215
+}
+
+// ScanBrightness finds the range of brightness in the image, so that
+// GetDepthPx() can stretch it to cover the full depth; with
+// --normalise-ignore-black, black pixels are left alone and don't count
+// towards the range
+func (hm *HeightmapImage) ScanBrightness() {
+	opt := hm.options
+
+	minBrightness := math.Inf(1)
+	maxBrightness := math.Inf(-1)
+
+	bounds := hm.img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			brightness := hm.Brightness(x, y)
+			if opt.NormaliseIgnoreBlack && brightness == 0 {
+				continue
+			}
+			minBrightness = math.Min(minBrightness, brightness)
+			maxBrightness = math.Max(maxBrightness, brightness)
+		}
+	}
+
+	hm.minBrightness = minBrightness
+	hm.maxBrightness = maxBrightness
+
+	// a flat image (or a completely black one) can't be stretched
+	hm.normalised = maxBrightness > minBrightness
+}
+
+func (hm *HeightmapImage) IsBottom(x, y float64) bool {
+	epsilon := 0.00001
+
+	return hm.GetDepth(x, y) < -hm.options.Depth+epsilon
+}
+
+func NewToolpointsMap(w, h int, options *Options, init float64) *ToolpointsMap {
+	tpm := ToolpointsMap{
+		w:             w,
+		h:             h,
+		hm:            nil,
+		height:        make([]float64, w*h),
+		options:       options,
+		initialHeight: init,
+	}
+
+	for i := 0; i < w*h; i++ {
+		tpm.height[i] = init
+	}
+
+	return &tpm
+}
+
+func (m *ToolpointsMap) SetMm(x, y, z float64) {
+	px, py := m.options.MmToPx(x, y)
+	m.SetPx(px, py, z)
+}
+
+func (m *ToolpointsMap) GetMm(x, y float64) float64 {
+	px, py := m.options.MmToPx(x, y)
+	return m.GetPx(px, py)
+}
+
+func (m *ToolpointsMap) SetPx(x, y int, z float64) {
+	if x < 0 || y < 0 || x >= m.w || y >= m.h {
+		return
+	}
+	m.height[y*m.w+x] = z
+}
+
+func (m *ToolpointsMap) GetPx(x, y int) float64 {
+	z := m.cutDepthPx(x, y)
+	for _, part := range m.holder {
+		if partZ := part.depth.GetPx(x, y) - part.height; partZ > z {
+			z = partZ
+		}
+	}
+	return z
+}
+
+// computePx works out the cut depth at (x, y) from the heightmap
+func (m *ToolpointsMap) computePx(x, y int) float64 {
+	xMm, yMm := m.options.PxToMm(x, y)
+	return m.hm.cutDepth(m.tool, xMm, yMm)
+}
+
+// cutDepthPx is GetPx() without lifting the tool clear of the holder
+func (m *ToolpointsMap) cutDepthPx(x, y int) float64 {
+	if x < 0 || y < 0 || x >= m.w || y >= m.h {
+		if m.hm == nil {
+			return math.Inf(-1)
+		} else {
+			return m.computePx(x, y)
+		}
+	}
+	if m.hm == nil || m.complete.Load() {
+		return m.height[y*m.w+x]
+	}
+
+	m.mu.RLock()
+	z := m.height[y*m.w+x]
+	m.mu.RUnlock()
+
+	if math.IsNaN(z) {
+		// if 2 goroutines get here at once they both work out the same
+		// depth, which is wasteful but harmless
+		z = m.computePx(x, y)
+		m.mu.Lock()
+		m.height[y*m.w+x] = z
+		m.mu.Unlock()
+		m.computed.Add(1)
+	}
+	return z
+}
+
+// Precompute fills in the cut depth for every pixel up front, instead of
+// leaving GetPx() to do it when each one is needed. The rows are shared out
+// in tiles between GOMAXPROCS goroutines; each pixel only depends on the
+// heightmap, so the result is the same however they are shared out, and the
+// same as GetPx() would give.
+func (m *ToolpointsMap) Precompute() {
+	m.precompute("Computing toolpoints")
+}
+
+func (m *ToolpointsMap) precompute(what string) {
+	if m.hm == nil || m.complete.Load() {
+		return
+	}
+
+	const tileRows = 4
+	tiles := (m.h + tileRows - 1) / tileRows
+
+	// non-rotary maps can be done a whole row at a time, which is much
+	// quicker (see cutDepthRows)
+	var rows *cutDepthRows
+	if !m.options.Rotary {
+		rows = m.hm.newCutDepthRows(m.tool)
+	}
+
+	nextTile := int64(-1)
+	done := make(chan int)
+
+	workers := runtime.GOMAXPROCS(0)
+	for i := 0; i < workers; i++ {
+		go func() {
+			var buf *rowBuffers
+			rowDepth := make([]float64, m.w)
+			if rows != nil {
+				buf = rows.newRowBuffers()
+			}
+
+			for {
+				tile := int(atomic.AddInt64(&nextTile, 1))
+				if tile >= tiles {
+					return
+				}
+
+				// tiles don't share rows, so the lock is only needed
+				// against GetPx() calls from elsewhere, and is taken once
+				// per row rather than once per pixel
+				for y := tile * tileRows; y < m.h && y < (tile+1)*tileRows; y++ {
+					row := m.height[y*m.w : (y+1)*m.w]
+
+					m.mu.RLock()
+					copy(rowDepth, row)
+					m.mu.RUnlock()
+
+					if rows != nil {
+						rows.Row(y, rowDepth, buf)
+					} else {
+						for x := range rowDepth {
+							if math.IsNaN(rowDepth[x]) {
+								rowDepth[x] = m.computePx(x, y)
+							}
+						}
+					}
+
+					n := int64(0)
+					m.mu.Lock()
+					for x, z := range row {
+						if math.IsNaN(z) {
+							row[x] = rowDepth[x]
+							n++
+						}
+					}
+					m.mu.Unlock()
+					m.computed.Add(n)
+				}
+				done <- tile
+			}
+		}()
+	}
+
+	for i := 0; i < tiles; i++ {
+		<-done
+		if !m.options.Quiet {
+			fmt.Fprintf(os.Stderr, "   \r%s: %.0f%%", what, float64(100*(i+1))/float64(tiles))
+		}
+	}
+	if !m.options.Quiet {
+		fmt.Fprintf(os.Stderr, "   \r%s: done\n", what)
+	}
+
+	m.complete.Store(true)
+}
+
+// WriteStock writes the stock remaining after cutting, in any of the formats
+// supported by HeightmapFormatFor()
+func (m *ToolpointsMap) WriteStock(path string, existingStock *HeightmapImage) error {
+	m2 := NewToolpointsMap(m.w, m.h, m.options, 0)
+	if existingStock != nil {
+		for y := 0; y < m2.h; y++ {
+			for x := 0; x < m2.w; x++ {
+				n := y*m2.w + x
+				m2.height[n] = existingStock.GetDepthPx(x, y)
+			}
+		}
+	}
+
+	if !m.options.Quiet {
+		fmt.Fprintf(os.Stderr, "Writing stock: 0%%")
+	}
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < m.w; x++ {
+			xMm, yMm := m.options.PxToMm(x, y)
+			if m.height[y*m.w+x] != m.initialHeight {
+				m2.PlotToolShape(xMm, yMm, m.height[y*m.w+x])
+			}
+		}
+
+		if !m.options.Quiet {
+			pct := float64(100 * y / m.h)
+			fmt.Fprintf(os.Stderr, "   \rWriting stock: %.0f%%", pct)
+		}
+	}
+
+	err := HeightmapFormatFor(path, m.options).Write(path, m2)
+	if err != nil {
+		return err
+	}
+
+	if !m.options.Quiet {
+		fmt.Fprintf(os.Stderr, "   \rWriting stock: done\n")
+	}
+
+	return nil
+}
+
+func (m *ToolpointsMap) PlotPixelMm(x, y, z float64) {
+	px, py := m.options.MmToPx(x, y)
+	m.PlotPixelPx(px, py, z)
+}
+
+func (m *ToolpointsMap) PlotPixelPx(px, py int, z float64) {
+	curZ := m.GetPx(px, py)
+	if math.IsNaN(curZ) || z < curZ {
+		m.SetPx(px, py, z)
+	}
+}
+
+func (m *ToolpointsMap) PlotToolShape(x, y, z float64) {
+	opt := m.options
+	tool := opt.Tool
+
+	xPx, yPx := opt.MmToPx(x, y)
+
+	r := tool.Radius()
+	rPxX := int(r/opt.x_MmPerPx) + 1
+	rPxY := int(r/opt.y_MmPerPx) + 1
+	if opt.Rotary {
+		rPxY = int(90.0/opt.y_MmPerPx) + 1
+	}
+
+	toolRadiusSqr := r * r
+
+	if opt.Rotary {
+		for sy := -rPxY; sy <= rPxY; sy++ {
+			for sx := -rPxX; sx <= rPxX; sx++ {
+				sxMm := float64(sx) * opt.x_MmPerPx
+				syDeg := float64(sy) * opt.y_MmPerPx // degrees
+
+				height := tool.LengthToIntersection(sxMm, syDeg, z)
+				m.PlotPixelPx(xPx+sx, yPx+sy, height-opt.Depth)
+			}
+		}
+	} else {
+		for sy := -rPxY; sy <= rPxY; sy++ {
+			for sx := -rPxX; sx <= rPxX; sx++ {
+				sxMm := float64(sx) * opt.x_MmPerPx
+				syMm := float64(sy) * opt.y_MmPerPx
+
+				rSqr := sxMm*sxMm + syMm*syMm
+				if rSqr > toolRadiusSqr {
+					continue
+				}
+				zOffset := tool.HeightAtRadiusSqr(rSqr)
+				m.PlotPixelPx(xPx+sx, yPx+sy, z+zOffset)
+			}
+		}
+	}
+}
+
+func (m *ToolpointsMap) PlotLine(x1, y1, z1, x2, y2, z2 float64) {
+	dx := x2 - x1
+	dy := y2 - y1
+	dz := z2 - z1
+
+	xyDist := math.Sqrt(dx*dx + dy*dy)
+
+	xStep := dx / xyDist
+	yStep := dy / xyDist
+	zStep := dz / xyDist
+
+	// TODO: might be wrong if x_MmPerPx is substantially different to y_MmPerPx
+	for k := 0.0; k <= xyDist; k += m.options.x_MmPerPx {
+		m.PlotPixelMm(x1+xStep*k, y1+yStep*k, z1+zStep*k)
+	}
+}
+
+func (m *ToolpointsMap) PlotToolpathSegment(seg *ToolpathSegment) {
+	if len(seg.points) == 0 {
+		return
+	}
+
+	if len(seg.points) == 1 {
+		m.PlotLine(seg.points[0].X, seg.points[0].Y, seg.points[0].Z, seg.points[0].X, seg.points[0].Y, seg.points[0].Z)
+		return
+	}
+
+	for i := 1; i < len(seg.points); i++ {
+		m.PlotLine(seg.points[i-1].X, seg.points[i-1].Y, seg.points[i-1].Z, seg.points[i].X, seg.points[i].Y, seg.points[i].Z)
+	}
+}
+
+func (m *ToolpointsMap) PlotToolpath(tp *Toolpath) {
+	for i := range tp.segments {
+		m.PlotToolpathSegment(&tp.segments[i])
+	}
+}
@@ -0,0 +1,215 @@
+package pngcam
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestHeightmap(t *testing.T) {
+	tool, err := NewTool("ball", 10)
+	if err != nil {
+		t.Errorf("can't create ball tool: %v", err)
+	}
+
+	opt := Options{
+		Width:  232,
+		Height: 650,
+		Depth:  10,
+
+		StepOver: 10,
+		StepDown: 1,
+
+		Directions: []Direction{Horizontal},
+
+		Tool:         tool,
+		StockToLeave: 0,
+
+		x_MmPerPx: 1,
+		y_MmPerPx: 1,
+	}
+
+	heightmap, err := OpenHeightmapImage("../../t/data/klingon-dagger.png", &opt)
+	if err != nil {
+		t.Errorf("can't open image: %v", err)
+	}
+
+	toolpointsmap := heightmap.ToToolpointsMap()
+	for y := 0; y < toolpointsmap.h; y += int(opt.StepOver) {
+		for x := 0; x < toolpointsmap.w; x++ {
+			z := toolpointsmap.GetPx(x, y)
+			if z < -opt.Depth {
+				t.Errorf("depth below bottom: %v,%v,%v", x, y, z)
+			}
+
+			if z > 0 {
+				t.Errorf("depth above top: %v,%v,%v", x, y, z)
+			}
+		}
+	}
+}
+
+func TestPrecompute(t *testing.T) {
+	tool, _ := NewTool("ball", 10)
+	opt := Options{
+		Depth:     10,
+		Tool:      tool,
+		Quiet:     true,
+		x_MmPerPx: 1,
+		y_MmPerPx: 1,
+	}
+
+	heightmap, err := OpenHeightmapImage("../../t/data/klingon-dagger.png", &opt)
+	if err != nil {
+		t.Fatalf("can't open image: %v", err)
+	}
+	opt.widthPx = heightmap.img.Bounds().Max.X
+	opt.heightPx = heightmap.img.Bounds().Max.Y
+
+	eager := heightmap.ToToolpointsMap()
+	eager.Precompute()
+
+	// fill in the lazy map from several goroutines at once, all of which
+	// want the same pixels
+	lazy := heightmap.ToToolpointsMap()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := 0; y < lazy.h; y++ {
+				for x := 0; x < lazy.w; x++ {
+					lazy.GetPx(x, y)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for y := 0; y < eager.h; y++ {
+		for x := 0; x < eager.w; x++ {
+			if eager.GetPx(x, y) != lazy.GetPx(x, y) {
+				t.Fatalf("precomputed depth at %v,%v should be %v, got %v", x, y, lazy.GetPx(x, y), eager.GetPx(x, y))
+			}
+		}
+	}
+}
+
+func TestFlipAndInvert(t *testing.T) {
+	opt := Options{
+		Depth: 10,
+	}
+
+	heightmap, err := OpenHeightmapImage("../../t/data/klingon-dagger.png", &opt)
+	if err != nil {
+		t.Fatalf("can't open image: %v", err)
+	}
+	opt.widthPx = heightmap.img.Bounds().Max.X
+	opt.heightPx = heightmap.img.Bounds().Max.Y
+
+	flipOpt := opt
+	flipOpt.XFlip = true
+	flipOpt.YFlip = true
+	flipOpt.Invert = true
+	flipped := &HeightmapImage{img: heightmap.img, options: &flipOpt}
+
+	for y := 0; y < opt.heightPx; y += 7 {
+		for x := 0; x < opt.widthPx; x += 7 {
+			z := heightmap.GetDepthPx(x, y)
+			zFlipped := flipped.GetDepthPx(opt.widthPx-1-x, opt.heightPx-1-y)
+			if math.Abs(z+zFlipped+opt.Depth) > 0.00001 {
+				t.Errorf("flipped and inverted depth at %v,%v should be %v, got %v", x, y, -opt.Depth-z, zFlipped)
+			}
+		}
+	}
+}
+
+func TestNormalise(t *testing.T) {
+	for _, ignoreBlack := range []bool{false, true} {
+		opt := Options{
+			Depth:                10,
+			Normalise:            true,
+			NormaliseIgnoreBlack: ignoreBlack,
+		}
+
+		heightmap, err := OpenHeightmapImage("../../t/data/klingon-dagger.png", &opt)
+		if err != nil {
+			t.Fatalf("can't open image: %v", err)
+		}
+		opt.widthPx = heightmap.img.Bounds().Max.X
+		opt.heightPx = heightmap.img.Bounds().Max.Y
+
+		heightmap.ScanBrightness()
+		if !heightmap.normalised {
+			t.Fatalf("heightmap should be normalised")
+		}
+
+		minZ := math.Inf(1)
+		maxZ := math.Inf(-1)
+		for y := 0; y < opt.heightPx; y++ {
+			for x := 0; x < opt.widthPx; x++ {
+				z := heightmap.GetDepthPx(x, y)
+				if ignoreBlack && heightmap.Brightness(x, y) == 0 {
+					if z != -opt.Depth {
+						t.Errorf("black should stay at full depth with ignore-black, got %v", z)
+					}
+					continue
+				}
+				minZ = math.Min(minZ, z)
+				maxZ = math.Max(maxZ, z)
+			}
+		}
+
+		if math.Abs(minZ+opt.Depth) > 0.00001 || math.Abs(maxZ) > 0.00001 {
+			t.Errorf("normalised depth should range from %v to 0 (ignore black = %v), got %v to %v", -opt.Depth, ignoreBlack, minZ, maxZ)
+		}
+	}
+}
+
+func TestSixteenBit(t *testing.T) {
+	opt := Options{
+		Depth:    10,
+		widthPx:  3,
+		heightPx: 1,
+		Width:    3,
+		Height:   1,
+		Grey16:   true,
+		Quiet:    true,
+	}
+
+	grey := image.NewGray16(image.Rect(0, 0, 3, 1))
+	grey.SetGray16(0, 0, color.Gray16{0})
+	grey.SetGray16(1, 0, color.Gray16{12345})
+	grey.SetGray16(2, 0, color.Gray16{65535})
+	hm := &HeightmapImage{img: grey, options: &opt}
+
+	checkFloat(t, "grey16 brightness", hm.Brightness(1, 0), 12345.0/65535)
+
+	// a grey pixel in 16-bit RGB should come out the same as in 16-bit greyscale
+	rgb := image.NewRGBA64(image.Rect(0, 0, 1, 1))
+	rgb.SetRGBA64(0, 0, color.RGBA64{12345, 12345, 12345, 65535})
+	checkFloat(t, "rgb48 grey brightness", (&HeightmapImage{img: rgb, options: &opt}).Brightness(0, 0), 12345.0/65535)
+
+	// otherwise R is most significant, then G, then B
+	rgb.SetRGBA64(0, 0, color.RGBA64{1, 2, 3, 65535})
+	checkFloat(t, "rgb48 brightness", (&HeightmapImage{img: rgb, options: &opt}).Brightness(0, 0), (4294967296+2*65536+3)/281474976710655.0)
+
+	// writing 16-bit stock should round-trip exactly
+	path := t.TempDir() + "/stock.png"
+	tpm := NewToolpointsMap(3, 1, &opt, 0)
+	if err := tpm.WriteStock(path, hm); err != nil {
+		t.Fatalf("can't write stock: %v", err)
+	}
+	stock, err := OpenHeightmapImage(path, &opt)
+	if err != nil {
+		t.Fatalf("can't read stock: %v", err)
+	}
+	if _, ok := stock.img.(*image.Gray16); !ok {
+		t.Errorf("stock should be written as 16-bit greyscale, got %T", stock.img)
+	}
+	for x := 0; x < 3; x++ {
+		checkFloat(t, "stock depth", stock.GetDepthPx(x, 0), hm.GetDepthPx(x, 0))
+	}
+}
@@ -0,0 +1,788 @@
+package pngcam
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"pngcam-go-render/render"
+)
+
+// A HeightmapFormat reads and writes heightmaps in one file format. Image
+// formats give brightness, which is scaled to --depth; float formats give
+// absolute heights in mm, returned as a *FloatImage. Formats that can carry a
+// description of the part also return it as key/value pairs (see TextWidth
+// etc.), otherwise the metadata is nil
+type HeightmapFormat interface {
+	Read(path string) (image.Image, map[string]string, error)
+	Write(path string, m *ToolpointsMap) error
+}
+
+// metadata keys written by pngcam-render
+const (
+	TextWidth  = render.TextWidth
+	TextHeight = render.TextHeight
+	TextDepth  = render.TextDepth
+	TextRotary = render.TextRotary
+	TextSide   = render.TextSide
+)
+
+type PNGFormat struct{}
+type TIFFFormat struct{}
+type PGMFormat struct{}
+type PFMFormat struct{}
+type RawFormat struct{}
+type STLFormat struct {
+	options *Options
+}
+
+// HeightmapFormatFor picks the format based on the file extension; anything
+// unrecognised is treated as PNG, like it always was
+func HeightmapFormatFor(path string, opt *Options) HeightmapFormat {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".tif" || ext == ".tiff" {
+		return &TIFFFormat{}
+	} else if ext == ".pgm" {
+		return &PGMFormat{}
+	} else if ext == ".pfm" {
+		return &PFMFormat{}
+	} else if ext == ".raw" {
+		return &RawFormat{}
+	} else if ext == ".stl" {
+		return &STLFormat{options: opt}
+	} else {
+		return &PNGFormat{}
+	}
+}
+
+// FloatImage holds absolute heights in mm, measured up from the bottom of the
+// stock, so 0 is at full depth and --depth is the top surface. It implements
+// image.Image so that it can be used anywhere an image can, but At() only
+// gives an approximation, at 1 micron per grey level
+type FloatImage struct {
+	w      int
+	h      int
+	height []float64
+}
+
+func NewFloatImage(w, h int) *FloatImage {
+	return &FloatImage{
+		w:      w,
+		h:      h,
+		height: make([]float64, w*h),
+	}
+}
+
+// Height returns 0 outside the image, which is the bottom of the stock, in the
+// same way as image.Image gives black
+func (img *FloatImage) Height(x, y int) float64 {
+	if x < 0 || y < 0 || x >= img.w || y >= img.h {
+		return 0
+	}
+	return img.height[y*img.w+x]
+}
+
+func (img *FloatImage) SetHeight(x, y int, z float64) {
+	img.height[y*img.w+x] = z
+}
+
+// checkFinite returns an error for the first NaN or infinite height, which
+// would otherwise get straight through the clamping in GetDepthPx()
+func (img *FloatImage) checkFinite(path string) error {
+	for i, z := range img.height {
+		if math.IsNaN(z) || math.IsInf(z, 0) {
+			return fmt.Errorf("%s: height at (%d,%d) is %g", path, i%img.w, i/img.w, z)
+		}
+	}
+	return nil
+}
+
+// samplesFit says whether n bytes hold at least w x h samples of size bytes
+// each, without overflowing on nonsense dimensions in a header
+func samplesFit(n, w, h, size int) bool {
+	return n/size/w >= h
+}
+
+func (img *FloatImage) ColorModel() color.Model {
+	return color.Gray16Model
+}
+
+func (img *FloatImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, img.w, img.h)
+}
+
+func (img *FloatImage) At(x, y int) color.Color {
+	microns := math.Round(img.Height(x, y) * 1000)
+	microns = math.Max(0, math.Min(65535, microns))
+	return color.Gray16{uint16(microns)}
+}
+
+// stockDepth returns the depth of the stock at image pixel (x,y), clamped to
+// the stock, and flipped so that the image can be read back in with the same
+// options
+func (m *ToolpointsMap) stockDepth(x, y int) float64 {
+	tx, ty := m.options.FlipPx(x, y)
+	z := m.height[ty*m.w+tx]
+	if z > 0 {
+		z = 0
+	}
+	if z < -m.options.Depth {
+		z = -m.options.Depth
+	}
+	return z
+}
+
+// stockHeight returns the height of the stock above the bottom in mm, which
+// reads back to the same depth with the same options
+func (m *ToolpointsMap) stockHeight(x, y int) float64 {
+	z := m.stockDepth(x, y)
+	if m.options.Invert {
+		return -z
+	}
+	return z + m.options.Depth
+}
+
+func (m *ToolpointsMap) stockGrey16(x, y int) uint16 {
+	brightness := int(math.Round(65535 * (m.stockDepth(x, y)/m.options.Depth + 1)))
+	if m.options.Invert {
+		brightness = 65535 - brightness
+	}
+	return uint16(brightness)
+}
+
+// Read gives the contents of the PNG's tEXt chunks as metadata
+func (f *PNGFormat) Read(path string) (image.Image, map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta, err := readPNGText(path, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return img, meta, nil
+}
+
+// readPNGText returns the tEXt chunks of the PNG file in data, which was read
+// from path
+func readPNGText(path string, data []byte) (map[string]string, error) {
+	if len(data) < 8 || string(data[:8]) != "\x89PNG\r\n\x1a\n" {
+		return nil, fmt.Errorf("%s: not a PNG file", path)
+	}
+
+	meta := make(map[string]string)
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) {
+			return nil, fmt.Errorf("%s: truncated PNG chunk", path)
+		}
+		if typ == "tEXt" {
+			kv := strings.SplitN(string(data[i+8:i+8+length]), "\x00", 2)
+			if len(kv) == 2 {
+				meta[kv[0]] = kv[1]
+			}
+		} else if typ == "IEND" {
+			break
+		}
+		i += 12 + length
+	}
+
+	return meta, nil
+}
+
+func (f *PNGFormat) Write(path string, m *ToolpointsMap) error {
+	var img image.Image
+
+	if m.options.Grey16 {
+		img16 := image.NewGray16(image.Rect(0, 0, m.w, m.h))
+		for y := 0; y < m.h; y++ {
+			for x := 0; x < m.w; x++ {
+				img16.SetGray16(x, y, color.Gray16{m.stockGrey16(x, y)})
+			}
+		}
+		img = img16
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, m.w, m.h))
+		for y := 0; y < m.h; y++ {
+			for x := 0; x < m.w; x++ {
+				n := y*m.w + x
+
+				brightness := int(16777215 * (m.stockDepth(x, y)/m.options.Depth + 1))
+				if m.options.Invert {
+					brightness = 16777215 - brightness
+				}
+
+				if m.options.RGB {
+					rgba.Pix[n*4] = uint8(brightness >> 16)
+					rgba.Pix[n*4+1] = uint8((brightness >> 8) & 0xff)
+					rgba.Pix[n*4+2] = uint8(brightness & 0xff)
+				} else {
+					rgba.Pix[n*4] = uint8(brightness >> 16)
+					rgba.Pix[n*4+1] = uint8(brightness >> 16)
+					rgba.Pix[n*4+2] = uint8(brightness >> 16)
+				}
+				rgba.Pix[n*4+3] = 255
+			}
+		}
+		img = rgba
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return png.Encode(out, img)
+}
+
+// TIFF tags that we care about
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffPhotometric     = 262
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPredictor       = 317
+	tiffTileWidth       = 322
+	tiffSampleFormat    = 339
+)
+
+// Read handles single-channel strip TIFFs, either uncompressed or deflated,
+// with no predictor. Float samples (32 or 64 bit) are heights in mm, and
+// unsigned 8 or 16 bit samples are brightness like PNG
+func (f *TIFFFormat) Read(path string) (image.Image, map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(data) < 8 {
+		return nil, nil, fmt.Errorf("%s: not a TIFF file", path)
+	}
+	var bo binary.ByteOrder
+	if string(data[0:2]) == "II" {
+		bo = binary.LittleEndian
+	} else if string(data[0:2]) == "MM" {
+		bo = binary.BigEndian
+	} else {
+		return nil, nil, fmt.Errorf("%s: not a TIFF file", path)
+	}
+	if bo.Uint16(data[2:4]) != 42 {
+		return nil, nil, fmt.Errorf("%s: not a TIFF file (BigTIFF is not supported)", path)
+	}
+
+	// read the first IFD, and ignore any others
+	ifd := int(bo.Uint32(data[4:8]))
+	if ifd+2 > len(data) {
+		return nil, nil, fmt.Errorf("%s: truncated TIFF file", path)
+	}
+	n := int(bo.Uint16(data[ifd:]))
+	if ifd+2+12*n > len(data) {
+		return nil, nil, fmt.Errorf("%s: truncated TIFF file", path)
+	}
+
+	tags := make(map[uint16][]uint32)
+	for i := 0; i < n; i++ {
+		e := data[ifd+2+12*i:]
+		tag := bo.Uint16(e)
+		typ := bo.Uint16(e[2:])
+		count := int(bo.Uint32(e[4:]))
+
+		size := 0
+		if typ == 3 { // SHORT
+			size = 2
+		} else if typ == 4 { // LONG
+			size = 4
+		} else {
+			// no tags we care about use any other types
+			continue
+		}
+
+		values := e[8:12]
+		if size*count > 4 {
+			offset := int(bo.Uint32(e[8:]))
+			if offset < 0 || offset+size*count > len(data) {
+				return nil, nil, fmt.Errorf("%s: truncated TIFF file", path)
+			}
+			values = data[offset:]
+		}
+		for k := 0; k < count; k++ {
+			if size == 2 {
+				tags[tag] = append(tags[tag], uint32(bo.Uint16(values[2*k:])))
+			} else {
+				tags[tag] = append(tags[tag], bo.Uint32(values[4*k:]))
+			}
+		}
+	}
+
+	tagValue := func(tag uint16, def uint32) uint32 {
+		if len(tags[tag]) == 0 {
+			return def
+		}
+		return tags[tag][0]
+	}
+
+	w := int(tagValue(tiffImageWidth, 0))
+	h := int(tagValue(tiffImageLength, 0))
+	bitsPerSample := int(tagValue(tiffBitsPerSample, 1))
+	compression := tagValue(tiffCompression, 1)
+	sampleFormat := tagValue(tiffSampleFormat, 1)
+
+	if w <= 0 || h <= 0 {
+		return nil, nil, fmt.Errorf("%s: bad TIFF dimensions %dx%d", path, w, h)
+	}
+	if tagValue(tiffSamplesPerPixel, 1) != 1 {
+		return nil, nil, fmt.Errorf("%s: only single-channel TIFF heightmaps are supported", path)
+	}
+	if len(tags[tiffTileWidth]) > 0 {
+		return nil, nil, fmt.Errorf("%s: tiled TIFFs are not supported", path)
+	}
+	if tagValue(tiffPredictor, 1) != 1 {
+		return nil, nil, fmt.Errorf("%s: TIFF predictors are not supported", path)
+	}
+	if compression != 1 && compression != 8 && compression != 32946 {
+		return nil, nil, fmt.Errorf("%s: unsupported TIFF compression %d", path, compression)
+	}
+
+	offsets := tags[tiffStripOffsets]
+	counts := tags[tiffStripByteCounts]
+	if len(offsets) == 0 || len(offsets) != len(counts) {
+		return nil, nil, fmt.Errorf("%s: bad TIFF strips", path)
+	}
+
+	pix := []byte{}
+	for i := range offsets {
+		start := int(offsets[i])
+		end := start + int(counts[i])
+		if start < 0 || end > len(data) || end < start {
+			return nil, nil, fmt.Errorf("%s: truncated TIFF file", path)
+		}
+		strip := data[start:end]
+		if compression != 1 {
+			r, err := zlib.NewReader(bytes.NewReader(strip))
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+			strip, err = io.ReadAll(r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		pix = append(pix, strip...)
+	}
+
+	bytesPerSample := bitsPerSample / 8
+	if bytesPerSample > 0 && !samplesFit(len(pix), w, h, bytesPerSample) {
+		return nil, nil, fmt.Errorf("%s: truncated TIFF image data", path)
+	}
+
+	if sampleFormat == 3 && (bitsPerSample == 32 || bitsPerSample == 64) {
+		img := NewFloatImage(w, h)
+		for i := 0; i < w*h; i++ {
+			if bitsPerSample == 32 {
+				img.height[i] = float64(math.Float32frombits(bo.Uint32(pix[4*i:])))
+			} else {
+				img.height[i] = math.Float64frombits(bo.Uint64(pix[8*i:]))
+			}
+		}
+		if err := img.checkFinite(path); err != nil {
+			return nil, nil, err
+		}
+		return img, nil, nil
+	} else if sampleFormat == 1 && bitsPerSample == 8 {
+		img := image.NewGray(image.Rect(0, 0, w, h))
+		copy(img.Pix, pix)
+		return img, nil, nil
+	} else if sampleFormat == 1 && bitsPerSample == 16 {
+		img := image.NewGray16(image.Rect(0, 0, w, h))
+		for i := 0; i < w*h; i++ {
+			img.Pix[2*i] = uint8(bo.Uint16(pix[2*i:]) >> 8)
+			img.Pix[2*i+1] = uint8(bo.Uint16(pix[2*i:]) & 0xff)
+		}
+		return img, nil, nil
+	} else {
+		return nil, nil, fmt.Errorf("%s: unsupported TIFF sample format %d with %d bits per sample", path, sampleFormat, bitsPerSample)
+	}
+}
+
+// Write writes an uncompressed little-endian 32-bit float TIFF, in a single strip
+func (f *TIFFFormat) Write(path string, m *ToolpointsMap) error {
+	bo := binary.LittleEndian
+	buf := bytes.Buffer{}
+
+	// header, followed immediately by the image data, followed by the IFD
+	dataLen := 4 * m.w * m.h
+	buf.WriteString("II")
+	binary.Write(&buf, bo, uint16(42))
+	binary.Write(&buf, bo, uint32(8+dataLen))
+
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < m.w; x++ {
+			binary.Write(&buf, bo, float32(m.stockHeight(x, y)))
+		}
+	}
+
+	type entry struct {
+		tag   uint16
+		typ   uint16
+		value uint32
+	}
+	entries := []entry{
+		{tiffImageWidth, 4, uint32(m.w)},
+		{tiffImageLength, 4, uint32(m.h)},
+		{tiffBitsPerSample, 3, 32},
+		{tiffCompression, 3, 1},
+		{tiffPhotometric, 3, 1}, // black is zero
+		{tiffStripOffsets, 4, 8},
+		{tiffSamplesPerPixel, 3, 1},
+		{tiffRowsPerStrip, 4, uint32(m.h)},
+		{tiffStripByteCounts, 4, uint32(dataLen)},
+		{tiffSampleFormat, 3, 3}, // IEEE float
+	}
+
+	binary.Write(&buf, bo, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, bo, e.tag)
+		binary.Write(&buf, bo, e.typ)
+		binary.Write(&buf, bo, uint32(1))
+		if e.typ == 3 {
+			// short values are left-justified in the value field
+			binary.Write(&buf, bo, uint16(e.value))
+			binary.Write(&buf, bo, uint16(0))
+		} else {
+			binary.Write(&buf, bo, e.value)
+		}
+	}
+	binary.Write(&buf, bo, uint32(0)) // no more IFDs
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// pnmToken reads the next whitespace-separated token from a PNM header,
+// skipping comments; it consumes the single whitespace character after the
+// token, so that binary data can follow immediately
+func pnmToken(r *bufio.Reader) (string, error) {
+	token := []byte{}
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				return string(token), nil
+			}
+			return "", err
+		}
+		if c == '#' && len(token) == 0 {
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			if len(token) > 0 {
+				return string(token), nil
+			}
+			continue
+		}
+		token = append(token, c)
+	}
+}
+
+// pnmHeader reads the magic number and the given number of integers that follow it
+func pnmHeader(r *bufio.Reader, path string, n int) (string, []int, error) {
+	magic, err := pnmToken(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	values := []int{}
+	for i := 0; i < n; i++ {
+		token, err := pnmToken(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %v", path, err)
+		}
+		v, err := strconv.Atoi(token)
+		if err != nil || v <= 0 {
+			return "", nil, fmt.Errorf("%s: bad header value %q", path, token)
+		}
+		values = append(values, v)
+	}
+
+	return magic, values, nil
+}
+
+// Read handles binary (P5) and plain (P2) PGM files, which give brightness like PNG
+func (f *PGMFormat) Read(path string) (image.Image, map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	magic, values, err := pnmHeader(r, path, 3)
+	if err != nil {
+		return nil, nil, err
+	}
+	if magic != "P5" && magic != "P2" {
+		return nil, nil, fmt.Errorf("%s: not a PGM file", path)
+	}
+	w, h, maxval := values[0], values[1], values[2]
+	if maxval > 65535 {
+		return nil, nil, fmt.Errorf("%s: bad PGM maxval %d", path, maxval)
+	}
+
+	img := image.NewGray16(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		v := 0
+		if magic == "P2" {
+			token, err := pnmToken(r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+			v, err = strconv.Atoi(token)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: bad PGM value %q", path, token)
+			}
+		} else if maxval < 256 {
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+			v = int(c)
+		} else {
+			var c uint16
+			if err := binary.Read(r, binary.BigEndian, &c); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+			v = int(c)
+		}
+		if v > maxval {
+			v = maxval
+		}
+
+		grey := uint16(math.Round(float64(v) * 65535 / float64(maxval)))
+		img.Pix[2*i] = uint8(grey >> 8)
+		img.Pix[2*i+1] = uint8(grey & 0xff)
+	}
+
+	return img, nil, nil
+}
+
+// Write writes a 16-bit binary PGM, regardless of --rgb or --grey16
+func (f *PGMFormat) Write(path string, m *ToolpointsMap) error {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "P5\n%d %d\n65535\n", m.w, m.h)
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < m.w; x++ {
+			binary.Write(&buf, binary.BigEndian, m.stockGrey16(x, y))
+		}
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Read handles greyscale PFM (Pf) files, which give heights in mm; the scale
+// factor only gives the byte order, and the rows are stored bottom-to-top
+func (f *PFMFormat) Read(path string) (image.Image, map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	magic, values, err := pnmHeader(r, path, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	if magic == "PF" {
+		return nil, nil, fmt.Errorf("%s: only greyscale PFM files are supported", path)
+	} else if magic != "Pf" {
+		return nil, nil, fmt.Errorf("%s: not a PFM file", path)
+	}
+	w, h := values[0], values[1]
+
+	token, err := pnmToken(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	scale, err := strconv.ParseFloat(token, 64)
+	if err != nil || scale == 0 {
+		return nil, nil, fmt.Errorf("%s: bad PFM scale %q", path, token)
+	}
+	var bo binary.ByteOrder = binary.BigEndian
+	if scale < 0 {
+		bo = binary.LittleEndian
+	}
+
+	// before allocating anything for the header's dimensions
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !samplesFit(int(info.Size()), w, h, 4) {
+		return nil, nil, fmt.Errorf("%s: truncated PFM file", path)
+	}
+
+	img := NewFloatImage(w, h)
+	row := make([]float32, w)
+	for y := h - 1; y >= 0; y-- {
+		if err := binary.Read(r, bo, row); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for x := 0; x < w; x++ {
+			img.SetHeight(x, y, float64(row[x]))
+		}
+	}
+	if err := img.checkFinite(path); err != nil {
+		return nil, nil, err
+	}
+
+	return img, nil, nil
+}
+
+func (f *PFMFormat) Write(path string, m *ToolpointsMap) error {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "Pf\n%d %d\n-1.0\n", m.w, m.h)
+	for y := m.h - 1; y >= 0; y-- {
+		for x := 0; x < m.w; x++ {
+			binary.Write(&buf, binary.LittleEndian, float32(m.stockHeight(x, y)))
+		}
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// rawHeaderPath gives the path of the sidecar header for a raw heightmap,
+// which is the same name with ".hdr" instead of ".raw"
+func rawHeaderPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".hdr"
+}
+
+// Read handles a grid of little-endian 32-bit floats, giving heights in mm,
+// top row first. The size comes from the sidecar header, which has a
+// "width N" line and a "height N" line; blank lines and lines starting with
+// "#" are ignored
+func (f *RawFormat) Read(path string) (image.Image, map[string]string, error) {
+	header, err := os.ReadFile(rawHeaderPath(path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w, h := 0, 0
+	for _, line := range strings.Split(string(header), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("%s: bad header line %q", rawHeaderPath(path), line)
+		}
+		v, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: bad header line %q", rawHeaderPath(path), line)
+		}
+		if fields[0] == "width" {
+			w = v
+		} else if fields[0] == "height" {
+			h = v
+		} else {
+			return nil, nil, fmt.Errorf("%s: unrecognised header key: %s", rawHeaderPath(path), fields[0])
+		}
+	}
+	if w <= 0 || h <= 0 {
+		return nil, nil, fmt.Errorf("%s: header must give a positive width and height", rawHeaderPath(path))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !samplesFit(len(data), w, h, 4) || len(data) != 4*w*h {
+		return nil, nil, fmt.Errorf("%s: expected 4 bytes for each of %dx%d floats, got %d bytes", path, w, h, len(data))
+	}
+
+	img := NewFloatImage(w, h)
+	for i := range img.height {
+		img.height[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:])))
+	}
+	if err := img.checkFinite(path); err != nil {
+		return nil, nil, err
+	}
+
+	return img, nil, nil
+}
+
+func (f *RawFormat) Write(path string, m *ToolpointsMap) error {
+	buf := bytes.Buffer{}
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < m.w; x++ {
+			binary.Write(&buf, binary.LittleEndian, float32(m.stockHeight(x, y)))
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	header := fmt.Sprintf("# pngcam raw heightmap: little-endian float32 heights in mm, top row first\nwidth %d\nheight %d\n", m.w, m.h)
+	return os.WriteFile(rawHeaderPath(path), []byte(header), 0644)
+}
+
+// Read renders the STL at --stl-resolution, giving heights in mm, and the same
+// metadata as pngcam-render would write
+func (f *STLFormat) Read(path string) (image.Image, map[string]string, error) {
+	opt := f.options
+
+	renderer, err := render.NewRenderer(&render.Options{
+		STLFile: path,
+		Rotary:  opt.Rotary,
+		Quiet:   opt.Quiet,
+		PxPerMm: opt.STLResolution,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	renderer.Draw()
+
+	hm := renderer.Heightmap()
+	w, h := hm.Size()
+	img := NewFloatImage(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetHeight(x, y, hm.HeightMm(x, y))
+		}
+	}
+
+	meta := make(map[string]string)
+	for _, kv := range hm.Text() {
+		meta[kv[0]] = kv[1]
+	}
+
+	return img, meta, nil
+}
+
+func (f *STLFormat) Write(path string, m *ToolpointsMap) error {
+	return fmt.Errorf("%s: can't write stock as STL", path)
+}
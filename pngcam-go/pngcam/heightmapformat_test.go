@@ -0,0 +1,256 @@
+package pngcam
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestHeightmapFormats(t *testing.T) {
+	for _, invert := range []bool{false, true} {
+		opt := Options{
+			Depth:    10,
+			widthPx:  3,
+			heightPx: 2,
+			Width:    3,
+			Height:   2,
+			Invert:   invert,
+			Quiet:    true,
+		}
+
+		// 16-bit values, so that PGM can round-trip exactly as well
+		grey := image.NewGray16(image.Rect(0, 0, 3, 2))
+		for i, v := range []uint16{0, 1000, 20000, 30000, 65000, 65535} {
+			grey.SetGray16(i%3, i/3, color.Gray16{v})
+		}
+		hm := &HeightmapImage{img: grey, options: &opt}
+
+		for _, ext := range []string{".png", ".tif", ".pgm", ".pfm", ".raw"} {
+			path := t.TempDir() + "/stock" + ext
+			opt.Grey16 = ext == ".png"
+
+			tpm := NewToolpointsMap(3, 2, &opt, 0)
+			if err := tpm.WriteStock(path, hm); err != nil {
+				t.Fatalf("can't write %s: %v", ext, err)
+			}
+			stock, err := OpenHeightmapImage(path, &opt)
+			if err != nil {
+				t.Fatalf("can't read %s: %v", ext, err)
+			}
+
+			for y := 0; y < 2; y++ {
+				for x := 0; x < 3; x++ {
+					checkFloat(t, ext+" depth", stock.GetDepthPx(x, y), hm.GetDepthPx(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestFloatHeights(t *testing.T) {
+	opt := Options{Depth: 10}
+
+	// heights are measured from the bottom of the stock, and clamped to it
+	img := NewFloatImage(4, 1)
+	for i, h := range []float64{-1, 2.5, 10, 12} {
+		img.SetHeight(i, 0, h)
+	}
+	hm := &HeightmapImage{img: img, options: &opt}
+
+	for i, want := range []float64{-10, -7.5, 0, 0} {
+		checkFloat(t, "float depth", hm.GetDepthPx(i, 0), want)
+	}
+}
+
+func TestReadTIFF(t *testing.T) {
+	// a big-endian, deflated, 2x1 float TIFF with one strip
+	compressed := bytes.Buffer{}
+	zw := zlib.NewWriter(&compressed)
+	binary.Write(zw, binary.BigEndian, []float32{1.5, 7.25})
+	zw.Close()
+
+	bo := binary.BigEndian
+	buf := bytes.Buffer{}
+	buf.WriteString("MM")
+	binary.Write(&buf, bo, uint16(42))
+	binary.Write(&buf, bo, uint32(8+compressed.Len()))
+	buf.Write(compressed.Bytes())
+
+	entries := [][3]uint32{
+		{tiffImageWidth, 3, 2},
+		{tiffImageLength, 3, 1},
+		{tiffBitsPerSample, 3, 32},
+		{tiffCompression, 3, 8},
+		{tiffStripOffsets, 4, 8},
+		{tiffStripByteCounts, 4, uint32(compressed.Len())},
+		{tiffSampleFormat, 3, 3},
+	}
+	binary.Write(&buf, bo, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, bo, uint16(e[0]))
+		binary.Write(&buf, bo, uint16(e[1]))
+		binary.Write(&buf, bo, uint32(1))
+		if e[1] == 3 {
+			binary.Write(&buf, bo, uint16(e[2]))
+			binary.Write(&buf, bo, uint16(0))
+		} else {
+			binary.Write(&buf, bo, e[2])
+		}
+	}
+	binary.Write(&buf, bo, uint32(0))
+
+	path := t.TempDir() + "/dem.tiff"
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("can't write TIFF: %v", err)
+	}
+
+	img, _, err := (&TIFFFormat{}).Read(path)
+	if err != nil {
+		t.Fatalf("can't read TIFF: %v", err)
+	}
+	floatImg, ok := img.(*FloatImage)
+	if !ok {
+		t.Fatalf("float TIFF should give *FloatImage, got %T", img)
+	}
+	checkFloat(t, "height(0,0)", floatImg.Height(0, 0), 1.5)
+	checkFloat(t, "height(1,0)", floatImg.Height(1, 0), 7.25)
+}
+
+func TestReadNonFiniteHeights(t *testing.T) {
+	dir := t.TempDir()
+
+	raw := bytes.Buffer{}
+	binary.Write(&raw, binary.LittleEndian, []float32{1, float32(math.NaN())})
+	if err := os.WriteFile(dir+"/nan.raw", raw.Bytes(), 0644); err != nil {
+		t.Fatalf("can't write raw: %v", err)
+	}
+	if err := os.WriteFile(dir+"/nan.hdr", []byte("width 2\nheight 1\n"), 0644); err != nil {
+		t.Fatalf("can't write raw header: %v", err)
+	}
+	if _, _, err := (&RawFormat{}).Read(dir + "/nan.raw"); err == nil {
+		t.Errorf("NaN in a raw heightmap should be an error")
+	}
+
+	pfm := bytes.Buffer{}
+	pfm.WriteString("Pf\n2 1\n-1.0\n")
+	binary.Write(&pfm, binary.LittleEndian, []float32{float32(math.Inf(1)), 1})
+	if err := os.WriteFile(dir+"/inf.pfm", pfm.Bytes(), 0644); err != nil {
+		t.Fatalf("can't write PFM: %v", err)
+	}
+	if _, _, err := (&PFMFormat{}).Read(dir + "/inf.pfm"); err == nil {
+		t.Errorf("infinity in a PFM heightmap should be an error")
+	}
+}
+
+func TestReadHugeDimensions(t *testing.T) {
+	dir := t.TempDir()
+
+	// 4 * w * h overflows to 0, which matches the empty file
+	if err := os.WriteFile(dir+"/huge.raw", []byte{}, 0644); err != nil {
+		t.Fatalf("can't write raw: %v", err)
+	}
+	if err := os.WriteFile(dir+"/huge.hdr", []byte("width 4611686018427387904\nheight 4\n"), 0644); err != nil {
+		t.Fatalf("can't write raw header: %v", err)
+	}
+	if _, _, err := (&RawFormat{}).Read(dir + "/huge.raw"); err == nil {
+		t.Errorf("raw heightmap bigger than its data should be an error")
+	}
+
+	// should fail without trying to allocate the image
+	if err := os.WriteFile(dir+"/huge.pfm", []byte("Pf\n1000000 1000000\n-1.0\n"), 0644); err != nil {
+		t.Fatalf("can't write PFM: %v", err)
+	}
+	if _, _, err := (&PFMFormat{}).Read(dir + "/huge.pfm"); err == nil {
+		t.Errorf("PFM heightmap bigger than its data should be an error")
+	}
+}
+
+func TestReadPlainPGM(t *testing.T) {
+	path := t.TempDir() + "/plain.pgm"
+	if err := os.WriteFile(path, []byte("P2\n# a comment\n3 1\n4\n0 1 4\n"), 0644); err != nil {
+		t.Fatalf("can't write PGM: %v", err)
+	}
+
+	hm, err := OpenHeightmapImage(path, &Options{Depth: 10})
+	if err != nil {
+		t.Fatalf("can't read PGM: %v", err)
+	}
+	for x, want := range []float64{0, 0.25, 1} {
+		if math.Abs(hm.Brightness(x, 0)-want) > 0.0001 {
+			t.Errorf("brightness(%d,0) should be %v, got %v", x, want, hm.Brightness(x, 0))
+		}
+	}
+}
+
+func TestReadPNGMetadata(t *testing.T) {
+	encoded := bytes.Buffer{}
+	png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 2, 2)))
+
+	// insert a tEXt chunk after the IHDR, like pngcam-render does
+	ihdrEnd := 8 + 4 + 4 + 13 + 4
+	chunk := []byte("tEXt" + TextDepth + "\x0012.5")
+	buf := bytes.Buffer{}
+	buf.Write(encoded.Bytes()[:ihdrEnd])
+	binary.Write(&buf, binary.BigEndian, uint32(len(chunk)-4))
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	buf.Write(encoded.Bytes()[ihdrEnd:])
+
+	path := t.TempDir() + "/part.png"
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("can't write PNG: %v", err)
+	}
+
+	hm, err := OpenHeightmapImage(path, &Options{})
+	if err != nil {
+		t.Fatalf("can't read PNG: %v", err)
+	}
+	if hm.metadata[TextDepth] != "12.5" {
+		t.Errorf("depth metadata should be 12.5, got %q", hm.metadata[TextDepth])
+	}
+}
+
+func TestReadSTL(t *testing.T) {
+	opt := Options{STLResolution: 5, Quiet: true}
+
+	hm, err := OpenHeightmapImage("../../t/data/keycap.stl", &opt)
+	if err != nil {
+		t.Fatalf("can't read STL: %v", err)
+	}
+
+	img, ok := hm.img.(*FloatImage)
+	if !ok {
+		t.Fatalf("STL should give *FloatImage, got %T", hm.img)
+	}
+
+	// 27.03x27.06 mm rounds up to a whole number of pixels
+	if img.w != 136 || img.h != 136 {
+		t.Errorf("STL should be 136x136 px, got %dx%d", img.w, img.h)
+	}
+	checkFloat(t, "width", mustParseFloat(t, hm.metadata[TextWidth]), 27.2)
+	checkFloat(t, "depth", mustParseFloat(t, hm.metadata[TextDepth]), 10.746858)
+
+	maxHeight := 0.0
+	for _, h := range img.height {
+		maxHeight = math.Max(maxHeight, h)
+	}
+	if math.Abs(maxHeight-10.746858) > 0.1 {
+		t.Errorf("top of the keycap should be at 10.75 mm, got %v", maxHeight)
+	}
+}
+
+func mustParseFloat(t *testing.T, s string) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		t.Fatalf("can't parse %q: %v", s, err)
+	}
+	return v
+}
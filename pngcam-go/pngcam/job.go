@@ -0,0 +1,617 @@
+package pngcam
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// A Job holds a heightmap and the options for cutting it, and generates the
+// toolpaths and G-code
+type Job struct {
+	options    *Options
+	toolpoints *ToolpointsMap
+	readStock  *ToolpointsMap
+	writeStock *ToolpointsMap
+	cycleTime  float64
+	// toolpoints lifted to keep the shank and holder clear of the part,
+	// and the pixels they're at, so each is only counted once
+	collisions []Collision
+	collided   map[[2]int]bool
+}
+
+// NewJob reads the heightmap from opt.HeightmapPath and works out the
+// toolpaths for it. The options are filled in from the heightmap where
+// necessary, so they shouldn't be shared between jobs.
+func NewJob(opt *Options) (*Job, error) {
+	hm, err := OpenHeightmapImage(opt.HeightmapPath, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return newJob(hm, opt)
+}
+
+// NewJobFromImage is like NewJob(), but for a heightmap that is already in
+// memory; the brightness of img gives the height, unless it is a *FloatImage
+func NewJobFromImage(img image.Image, opt *Options) (*Job, error) {
+	return newJob(&HeightmapImage{img: img, options: opt}, opt)
+}
+
+// NewJobFromHeights is like NewJob(), but for a w x h grid of heights in mm
+// above the bottom of the stock, top row first
+func NewJobFromHeights(w, h int, heights []float64, opt *Options) (*Job, error) {
+	if len(heights) != w*h {
+		return nil, fmt.Errorf("%dx%d heightmap needs %d heights, got %d", w, h, w*h, len(heights))
+	}
+
+	img := NewFloatImage(w, h)
+	copy(img.height, heights)
+
+	return NewJobFromImage(img, opt)
+}
+
+func newJob(hm *HeightmapImage, opt *Options) (*Job, error) {
+	j := Job{}
+	j.options = opt
+
+	for _, warning := range opt.ApplyMetadata(hm.metadata) {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	// rotary parts are always 360 degrees around (should this be configurable?
+	// e.g. to allow partial rotation?), so there's no aspect ratio to maintain
+	if opt.Rotary {
+		opt.Height = 360.0
+		opt.SafeZ += opt.Depth
+		if opt.Width == 0 {
+			opt.Width = 100
+		}
+	} else {
+		for _, dir := range opt.Directions {
+			if dir == Helical {
+				return nil, fmt.Errorf("can't use helical paths in non-rotary mode")
+			}
+		}
+	}
+
+	if !opt.Rotary && !opt.ApplyAspectRatio(hm.img.Bounds().Max.X, hm.img.Bounds().Max.Y) {
+		fmt.Fprintf(os.Stderr, "warning: %gx%g work piece from %dx%d px height map gives non-square pixels\n", opt.Width, opt.Height, hm.img.Bounds().Max.X, hm.img.Bounds().Max.Y)
+	}
+
+	opt.x_MmPerPx = opt.Width / float64(hm.img.Bounds().Max.X)
+	opt.y_MmPerPx = opt.Height / float64(hm.img.Bounds().Max.Y)
+	opt.widthPx = hm.img.Bounds().Max.X
+	opt.heightPx = hm.img.Bounds().Max.Y
+
+	if opt.Normalise {
+		hm.ScanBrightness()
+	}
+
+	j.toolpoints = hm.ToToolpointsMap()
+
+	if opt.ReadStockPath != "" {
+		readImg, err := OpenHeightmapImage(opt.ReadStockPath, opt)
+		if err != nil {
+			return nil, err
+		}
+		j.readStock = readImg.ToToolpointsMap()
+	}
+
+	if opt.WriteStockPath != "" {
+		initialDepth := 0.0
+		if opt.Rotary {
+			initialDepth = opt.Depth
+		}
+		j.writeStock = NewToolpointsMap(hm.img.Bounds().Max.X, hm.img.Bounds().Max.Y, opt, initialDepth)
+	}
+
+	if !opt.Quiet {
+		unit := "mm"
+		if opt.Imperial {
+			unit = "inches"
+		}
+		fmt.Fprintf(os.Stderr, "%dx%d px height map. %gx%g %s work piece.\n", opt.widthPx, opt.heightPx, opt.Width, opt.Height, unit)
+		if side, ok := hm.metadata[TextSide]; ok {
+			fmt.Fprintf(os.Stderr, "Height map shows the %s side of the part.\n", side)
+		}
+		fmt.Fprintf(os.Stderr, "X resolution is %g px/%s. Y resolution is %g px/%s.\n", 1/opt.x_MmPerPx, unit, 1/opt.y_MmPerPx, unit)
+		fmt.Fprintf(os.Stderr, "Step-over is %g %s = %g px in X and %g px in Y.\n", opt.StepOver, unit, opt.StepOver/opt.x_MmPerPx, opt.StepOver/opt.y_MmPerPx)
+		if opt.StepForward > 0 {
+			fmt.Fprintf(os.Stderr, "Step-forward is %g %s = %g px in X and %g px in Y.\n", opt.StepForward, unit, opt.StepForward/opt.x_MmPerPx, opt.StepForward/opt.y_MmPerPx)
+		}
+		if opt.Normalise {
+			ignoring := ""
+			if opt.NormaliseIgnoreBlack {
+				ignoring = " (ignoring black)"
+			}
+			if hm.normalised {
+				fmt.Fprintf(os.Stderr, "Brightness range is %g to %g%s. Normalising to full depth.\n", hm.minBrightness, hm.maxBrightness, ignoring)
+			} else {
+				fmt.Fprintf(os.Stderr, "Brightness is constant%s. Not normalising.\n", ignoring)
+			}
+		}
+	}
+
+	holder, err := hm.holderParts()
+	if err != nil {
+		return nil, err
+	}
+	j.toolpoints.holder = holder
+
+	if opt.CacheDir != "" {
+		n, err := j.toolpoints.ReadCache(opt.CacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: can't read toolpoints cache: %v\n", err)
+		} else if !opt.Quiet && n > 0 {
+			fmt.Fprintf(os.Stderr, "Read %d toolpoints from cache.\n", n)
+		}
+	}
+
+	if opt.Precompute {
+		j.toolpoints.Precompute()
+	}
+	for _, part := range holder {
+		// the shank and holder are usually much bigger than the cutter, so
+		// working them out a pixel at a time would be slow, except in rotary
+		// mode where it's the only way
+		if opt.Precompute || !opt.Rotary {
+			part.depth.precompute("Computing " + part.name + " clearance")
+		}
+	}
+
+	// the routes aren't kept, and are made again as the G-code is written,
+	// so this is only to find out up front whether the shank or holder will
+	// hit the part
+	if len(holder) > 0 {
+		for _, dir := range opt.Directions {
+			j.makeToolpath(dir, "Checking clearance")
+		}
+	}
+
+	if len(j.collisions) > 0 && opt.FailOnCollision {
+		return nil, fmt.Errorf("%s", j.collisionReport())
+	} else if len(j.collisions) > 0 && !opt.Quiet {
+		maxLift := 0.0
+		for _, c := range j.collisions {
+			maxLift = math.Max(maxLift, c.Lift)
+		}
+		fmt.Fprintf(os.Stderr, "warning: lifted the tool at %d toolpoints, by up to %.3f, to keep the shank and holder clear of the part; this leaves rest material for a longer or narrower tool\n", len(j.collisions), maxLift)
+	}
+
+	return &j, nil
+}
+
+// MakeToolpath returns the passes over the heightmap for one route, which
+// are the finishing passes before they are sorted and joined up
+func (j *Job) MakeToolpath(direction Direction) Toolpath {
+	return j.makeToolpath(direction, "Generating path")
+}
+
+func (j *Job) makeToolpath(direction Direction, what string) Toolpath {
+	path := NewToolpath()
+
+	opt := j.options
+
+	xLimit := opt.Width
+	yLimit := opt.Height
+
+	xStep := opt.XStepForward()
+	yStep := 0.0
+	if direction == Vertical {
+		xStep = 0.0
+		yStep = opt.YStepForward()
+	} else if direction == Helical {
+		// advance by stepOver per revolution
+		yStep = opt.YStepForward()
+		xStep = opt.StepOver * yStep / opt.Height
+	}
+
+	zero := 0.0
+
+	if opt.CutBeyondEdges {
+		extraLimit := opt.Tool.Radius()
+		zero -= extraLimit
+		xLimit += extraLimit
+		yLimit += extraLimit
+	}
+
+	// with a coarse step-forward, the last point of a pass can fall well short
+	// of the far edge, so let each pass overshoot by up to one step and clamp
+	// points to the last pixel
+	xOvershoot := 0.0
+	yOvershoot := 0.0
+	if opt.StepForward > 0 {
+		if direction == Horizontal {
+			xOvershoot = xStep
+		} else if direction == Vertical {
+			yOvershoot = yStep
+		}
+	}
+
+	x := zero
+	y := zero
+
+	if !opt.Quiet {
+		fmt.Fprintf(os.Stderr, "%s: 0%%", what)
+	}
+
+	for x >= zero && y >= zero && x < xLimit+xOvershoot && y < yLimit+yOvershoot {
+		seg := NewToolpathSegment()
+
+		// TODO: use CutPath() instead of this weird dual-loop thing
+		for x >= zero && y >= zero && x < xLimit+xOvershoot && (y < yLimit+yOvershoot || direction == Helical) {
+			px := x
+			py := y
+			if xOvershoot > 0 {
+				px = math.Min(px, xLimit-opt.x_MmPerPx)
+			}
+			if yOvershoot > 0 {
+				py = math.Min(py, yLimit-opt.y_MmPerPx)
+			}
+			n := len(seg.points)
+			if n == 0 || seg.points[n-1].X != px || seg.points[n-1].Y != py {
+				z := j.toolpoints.GetMm(px, py)
+				if lift, part := j.toolpoints.HolderLiftMm(px, py); lift > 0 {
+					j.addCollision(Collision{X: px, Y: py, Z: z, Lift: lift, Part: part})
+				}
+				seg.Append(Toolpoint{px, py, z, CuttingFeed})
+			}
+
+			x += xStep
+			y += yStep
+		}
+
+		if opt.OmitTop || opt.OmitBottom {
+			path.AppendToolpath(seg.OmitTopAndBottom(opt).Simplified(opt.Tolerance))
+		} else {
+			path.Append(seg.Simplified(opt.Tolerance))
+		}
+
+		pct := 0.0
+		if direction == Horizontal {
+			y += opt.StepOver
+			pct = float64(100*(y-zero)) / (yLimit - zero)
+		} else if direction == Vertical {
+			x += opt.StepOver
+			pct = float64(100*(x-zero)) / (xLimit - zero)
+		} else if direction == Helical {
+			break
+		} else {
+			panic("unimplemented direction")
+		}
+
+		xStep = -xStep
+		yStep = -yStep
+		x += xStep
+		y += yStep
+
+		if !opt.Quiet {
+			fmt.Fprintf(os.Stderr, "   \r%s: %.0f%%", what, pct)
+		}
+	}
+
+	if !opt.Quiet {
+		fmt.Fprintf(os.Stderr, "   \r%s: done\n", what)
+	}
+
+	return path
+}
+
+// Toolpath returns the whole toolpath for the job: roughing, then finishing
+func (j *Job) Toolpath() *Toolpath {
+	opt := j.options
+
+	path := NewToolpath()
+	j.eachToolpath(func(tp *Toolpath) {
+		path.AppendToolpath(tp)
+	})
+
+	if opt.RampEntry {
+		return path.RampEntry(*opt)
+	}
+	return &path
+}
+
+// eachToolpath generates the program a part at a time, in the order it is
+// cut, and gives each part to fn: the roughing levels, then the finishing
+// passes of each route. Only one route is held in memory at once.
+func (j *Job) eachToolpath(fn func(tp *Toolpath)) {
+	opt := j.options
+
+	for i, dir := range opt.Directions {
+		if i > 0 && opt.RoughingOnly {
+			break
+		}
+
+		route := j.MakeToolpath(dir)
+		if i == 0 {
+			j.roughing(&route, fn)
+		}
+		if !opt.RoughingOnly {
+			fn(j.finishing(&route))
+		}
+	}
+
+	// by now we've looked up every toolpoint we're going to need
+	if opt.CacheDir != "" {
+		n, err := j.toolpoints.WriteCache(opt.CacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: can't write toolpoints cache: %v\n", err)
+		} else if !opt.Quiet && n > 0 {
+			fmt.Fprintf(os.Stderr, "Wrote %d toolpoints to cache.\n", n)
+		}
+	}
+}
+
+// WriteGcode writes the G-code program to w as it is generated, a part at a
+// time, so that the whole program is never held in memory, and writes the
+// stock heightmap if WriteStockPath is set; afterwards CycleTime() returns the
+// estimated time to run it
+func (j *Job) WriteGcode(w io.Writer) error {
+	opt := j.options
+
+	g := NewGcodeWriter(w, *opt)
+	g.WriteString(j.Preamble())
+
+	if opt.RampEntry {
+		// the whole program is ramped as one segment, like Toolpath.RampEntry()
+		var prev *Toolpoint
+		ramp := rampEntry{out: func(p Toolpoint) {
+			g.AppendPoint(p)
+			if j.writeStock != nil && prev != nil {
+				j.writeStock.PlotLine(prev.X, prev.Y, prev.Z, p.X, p.Y, p.Z)
+			}
+			prev = &p
+		}}
+		j.eachToolpath(func(tp *Toolpath) {
+			tp.eachPoint(*opt, ramp.Add)
+		})
+		ramp.Close()
+		g.EndSegment()
+	} else {
+		j.eachToolpath(func(tp *Toolpath) {
+			g.WriteToolpath(tp)
+			if j.writeStock != nil {
+				j.writeStock.PlotToolpath(tp)
+			}
+		})
+	}
+
+	g.WriteString(j.Postamble())
+	err := g.Flush()
+
+	j.cycleTime = g.CycleTime()
+
+	if j.writeStock != nil {
+		var hm *HeightmapImage
+		if j.readStock != nil {
+			hm = j.readStock.hm
+		}
+		stockErr := j.writeStock.WriteStock(opt.WriteStockPath, hm)
+		if stockErr != nil && err == nil {
+			err = fmt.Errorf("write %s: %v", opt.WriteStockPath, stockErr)
+		}
+	}
+
+	if !opt.Quiet {
+		fmt.Fprintf(os.Stderr, "Cycle time estimate: %g secs\n", j.cycleTime)
+	}
+
+	return err
+}
+
+// CycleTime returns the estimated time in seconds to run the program from
+// the last call to WriteGcode() or Gcode()
+func (j *Job) CycleTime() float64 {
+	return j.cycleTime
+}
+
+// Gcode returns the whole G-code program as a string
+func (j *Job) Gcode() string {
+	gcode := strings.Builder{}
+	j.WriteGcode(&gcode)
+	return gcode.String()
+}
+
+func (j *Job) Preamble() string {
+	return j.options.Post.Preamble(*j.options)
+}
+
+func (j *Job) Postamble() string {
+	return j.options.Post.Postamble(*j.options)
+}
+
+func (j *Job) Finishing() *Toolpath {
+	path := NewToolpath()
+
+	for _, dir := range j.options.Directions {
+		route := j.MakeToolpath(dir)
+		path.AppendToolpath(j.finishing(&route))
+	}
+
+	return &path
+}
+
+// finishing sorts and joins up the passes of one route; each route is done
+// separately, so that they are cut one after the other, and the passes were
+// already simplified by MakeToolpath()
+func (j *Job) finishing(route *Toolpath) *Toolpath {
+	return j.CombineSegments(route.Sorted())
+}
+
+func (j *Job) Roughing() *Toolpath {
+	path := NewToolpath()
+
+	// the first route is enough to clear the material, subsequent routes are
+	// only needed for finishing
+	route := j.MakeToolpath(j.options.Directions[0])
+	j.roughing(&route, func(tp *Toolpath) {
+		path.AppendToolpath(tp)
+	})
+
+	return &path
+}
+
+// roughing gives each level of roughing, from the passes of route, to fn in turn
+func (j *Job) roughing(route *Toolpath, fn func(tp *Toolpath)) {
+	opt := j.options
+
+	deepest := -opt.Depth
+	if opt.CutBelowBottom {
+		deepest -= opt.Tool.Radius()
+	}
+
+	if opt.Rotary {
+		for z := opt.Depth - opt.StepDown; z > 0; z -= opt.StepDown {
+			fn(j.roughingLevel(route, z).Simplified(opt.Tolerance).Sorted())
+		}
+	} else {
+		for z := -opt.StepDown; z > deepest; z -= opt.StepDown {
+			fn(j.roughingLevel(route, z).Simplified(opt.Tolerance).Sorted())
+		}
+	}
+}
+
+func (j *Job) RoughingLevel(z float64) *Toolpath {
+	route := j.MakeToolpath(j.options.Directions[0])
+	return j.roughingLevel(&route, z)
+}
+
+func (j *Job) roughingLevel(route *Toolpath, z float64) *Toolpath {
+	path := NewToolpath()
+
+	for i := range route.segments {
+		seg := NewToolpathSegment()
+		for p := range route.segments[i].points {
+			tp := route.segments[i].points[p]
+			if tp.Z < z && (j.readStock == nil || z < j.readStock.GetMm(tp.X, tp.Y)) {
+				// add this point to this roughing segment
+				seg.Append(Toolpoint{tp.X, tp.Y, z, CuttingFeed})
+			} else {
+				// this point isn't in this segment: append what we have and make a new segment
+				if len(seg.points) > 0 {
+					path.Append(seg)
+				}
+				seg = NewToolpathSegment()
+			}
+		}
+
+		if len(seg.points) > 0 {
+			path.Append(seg)
+		}
+	}
+
+	return j.CombineSegments(path.Sorted())
+}
+
+func (j *Job) CombineSegments(tp *Toolpath) *Toolpath {
+	opt := j.options
+
+	if len(tp.segments) <= 1 {
+		return tp
+	}
+
+	newtp := NewToolpath()
+
+	// TODO: what happens when there are 0-length segments?
+	// TODO: if opt.omitTop, do we need to take pains to omit top?
+
+	seg := tp.segments[0]
+
+	for i := 1; i < len(tp.segments); i++ {
+		prev := seg.points[len(seg.points)-1]
+		cur := tp.segments[i].points[0]
+
+		// the rapid path leaves us above cur, and the next segment then has
+		// to feed down to it, so include that in the comparison
+		rapidPath := tp.RapidPath(prev, cur, *opt)
+		rapidPath.Append(Toolpoint{cur.X, cur.Y, cur.Z, CuttingFeed})
+		deepestZ := prev.Z
+		if cur.Z < deepestZ {
+			deepestZ = cur.Z
+		}
+		cutPath := j.CutPath(prev, cur, deepestZ)
+		cutPath = cutPath.Simplified(opt.Tolerance)
+
+		// as well as a straight line from prev to cur, try axis-aligned lines
+		// in x-first and y-first configuration
+		xCur := Toolpoint{X: cur.X, Y: prev.Y, Z: math.Max(deepestZ, j.toolpoints.GetMm(cur.X, prev.Y)), Feed: CuttingFeed}
+		yCur := Toolpoint{X: prev.X, Y: cur.Y, Z: math.Max(deepestZ, j.toolpoints.GetMm(prev.X, cur.Y)), Feed: CuttingFeed}
+		xYCutPath := j.CutPath(prev, xCur, deepestZ)
+		xYCutPath2 := j.CutPath(xCur, cur, deepestZ)
+		xYCutPath.AppendSegment(&xYCutPath2)
+		xYCutPath = xYCutPath.Simplified(opt.Tolerance)
+		yXCutPath := j.CutPath(prev, yCur, deepestZ)
+		yXCutPath2 := j.CutPath(yCur, cur, deepestZ)
+		yXCutPath.AppendSegment(&yXCutPath2)
+		yXCutPath = yXCutPath.Simplified(opt.Tolerance)
+
+		if xYCutPath.CycleTime(*opt) < cutPath.CycleTime(*opt) {
+			cutPath = xYCutPath
+		}
+		if yXCutPath.CycleTime(*opt) < cutPath.CycleTime(*opt) {
+			cutPath = yXCutPath
+		}
+
+		// when we have a cutting path that is faster than the rapid path, use it instead
+		if cutPath.CycleTime(*opt) < rapidPath.CycleTime(*opt) {
+			seg.AppendSegment(&cutPath)
+		} else {
+			newtp.Append(seg)
+			seg = NewToolpathSegment()
+		}
+		seg.AppendSegment(&tp.segments[i])
+	}
+
+	if len(seg.points) > 0 {
+		newtp.Append(seg)
+	}
+
+	return &newtp
+}
+
+func (j *Job) CutPath(a, b Toolpoint, deepestZ float64) ToolpathSegment {
+	x := a.X
+	y := a.Y
+
+	dx := b.X - a.X
+	dy := b.Y - a.Y
+	dist := math.Sqrt(dx*dx + dy*dy)
+
+	// on long travels, we would take unsightly gouges out of the surface
+	// pattern, due to the stepOver, even though we technically keep the
+	// tool on the surface of the model; to mitigate this, we lift the tool
+	// up by the "nominal deviation"
+	r1 := j.options.Tool.Radius()
+	r2 := j.options.StepOver / 2
+	deviation := r1 - math.Sqrt(r1*r1-r2*r2)
+
+	dx /= dist
+	dy /= dist
+
+	seg := NewToolpathSegment()
+
+	// TODO: allow rapid through the middle of material that we've already cut,
+	// using the writeStock.GetMm() to decide?
+
+	// TODO: might be wrong if x_MmPerPx is substantially different to y_MmPerPx
+	for k := 0.0; k <= dist; k += j.options.XStepForward() {
+		x = a.X + k*dx
+		y = a.Y + k*dy
+
+		z := j.toolpoints.GetMm(x, y)
+		if z < deepestZ {
+			z = deepestZ
+		}
+
+		seg.Append(Toolpoint{x, y, z + deviation, CuttingFeed})
+	}
+
+	seg.Append(b)
+
+	return seg
+}
@@ -0,0 +1,172 @@
+package pngcam
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestJobFromHeights(t *testing.T) {
+	// a 10 mm square with a 2 mm deep channel down the middle
+	w, h := 20, 20
+	heights := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			heights[y*w+x] = 5
+			if x >= 8 && x < 12 {
+				heights[y*w+x] = 3
+			}
+		}
+	}
+
+	opt := DefaultOptions()
+	opt.Width = 10
+	opt.Depth = 5
+	opt.StepOver = 1
+	opt.Quiet = true
+	opt.Tool, _ = NewTool("flat", 1)
+
+	job, err := NewJobFromHeights(w, h, heights, &opt)
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	if opt.Height != 10 {
+		t.Errorf("height should come from the aspect ratio, got %v", opt.Height)
+	}
+
+	minZ := 0.0
+	for _, seg := range job.Toolpath().Segments() {
+		for _, p := range seg.Points() {
+			if p.Z < minZ {
+				minZ = p.Z
+			}
+		}
+	}
+	checkFloat(t, "deepest cut", minZ, -2)
+
+	gcode := bytes.Buffer{}
+	if err := job.WriteGcode(&gcode); err != nil {
+		t.Fatalf("can't write G-code: %v", err)
+	}
+	if !strings.HasPrefix(gcode.String(), "G21\n") || !strings.HasSuffix(gcode.String(), "M2\n") {
+		t.Errorf("G-code should be a complete program, got %q", gcode.String())
+	}
+	checkFloat(t, "cycle time", job.CycleTime(), job.Toolpath().CycleTime(opt))
+
+	_, err = NewJobFromHeights(w, h, heights[1:], &opt)
+	if err == nil {
+		t.Errorf("wrong number of heights should be an error")
+	}
+}
+
+func TestRapidsAboveSurface(t *testing.T) {
+	opt := DefaultOptions()
+	opt.HeightmapPath = "../../t/data/klingon-dagger.png"
+	opt.Width = 23.2
+	opt.Height = 65
+	opt.StepOver = 2
+	opt.StepDown = 3
+	opt.Quiet = true
+	opt.Tool, _ = NewTool("ball", 3)
+
+	job, err := NewJob(&opt)
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	gcode := bytes.Buffer{}
+	if err := job.WriteGcode(&gcode); err != nil {
+		t.Fatalf("can't write G-code: %v", err)
+	}
+
+	// rapids can go straight down to the roughing level that has already
+	// been cleared, but must never move sideways below the top surface
+	var x, y, z float64
+	for _, line := range strings.Split(gcode.String(), "\n") {
+		var cmd string
+		var nx, ny, nz float64
+		if n, _ := fmt.Sscanf(line, "%s X%f Y%f Z%f", &cmd, &nx, &ny, &nz); n != 4 {
+			continue
+		}
+		if cmd == "G0" && (nx != x || ny != y) && (nz < 0 || z < 0) {
+			t.Errorf("rapid through material from %v,%v,%v: %q", x, y, z, line)
+		}
+		x, y, z = nx, ny, nz
+	}
+}
+
+func TestStreamedGcode(t *testing.T) {
+	// bumps in both directions, so that there are arcs, and deep enough for
+	// a few levels of roughing to ramp into
+	w, h := 60, 60
+	heights := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			heights[y*w+x] = 5 + 4*math.Sin(float64(x)/6)*math.Cos(float64(y)/8)
+		}
+	}
+
+	opt := DefaultOptions()
+	opt.Width = 30
+	opt.Depth = 10
+	opt.StepOver = 2
+	opt.StepDown = 3
+	opt.ArcTolerance = 0.01
+	opt.Quiet = true
+	opt.Tool, _ = NewTool("ball", 2)
+
+	job, err := NewJobFromHeights(w, h, heights, &opt)
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+
+	// the same toolpath for both, because sorting doesn't always break ties
+	// the same way
+	tp := job.Toolpath()
+	if !strings.Contains(tp.ToGcode(opt), "G2 ") {
+		t.Errorf("test toolpath should have arcs")
+	}
+
+	// WriteGcode() writes the program a part at a time
+	parts := []*Toolpath{{segments: tp.segments[:len(tp.segments)/2]}, {segments: tp.segments[len(tp.segments)/2:]}}
+	got := strings.Builder{}
+	g := NewGcodeWriter(&got, opt)
+	for _, part := range parts {
+		g.WriteToolpath(part)
+	}
+	g.Flush()
+	if got.String() != tp.ToGcode(opt) {
+		t.Errorf("G-code written a part at a time differs from the whole toolpath's")
+	}
+
+	// and with ramp entry, a point at a time
+	got = strings.Builder{}
+	g = NewGcodeWriter(&got, opt)
+	ramp := rampEntry{out: g.AppendPoint}
+	for _, part := range parts {
+		part.eachPoint(opt, ramp.Add)
+	}
+	ramp.Close()
+	g.EndSegment()
+	g.Flush()
+	if got.String() != tp.RampEntry(opt).ToGcode(opt) {
+		t.Errorf("ramped G-code written a point at a time differs from the whole toolpath's")
+	}
+}
+
+func TestWriteStockError(t *testing.T) {
+	opt := DefaultOptions()
+	opt.Width = 10
+	opt.Quiet = true
+	opt.WriteStockPath = t.TempDir() + "/missing/stock.png"
+	opt.Tool, _ = NewTool("flat", 1)
+
+	job, err := NewJobFromHeights(2, 2, []float64{0, 5, 5, 10}, &opt)
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	if err := job.WriteGcode(&bytes.Buffer{}); err == nil {
+		t.Errorf("failing to write the stock should be an error")
+	}
+}
@@ -0,0 +1,253 @@
+package pngcam
+
+import (
+	"math"
+)
+
+// a single straight-line move, as seen by the motion planner; distances are
+// in axis units (mm, or degrees for the rotary axis) and times in seconds
+type plannedMove struct {
+	length        float64
+	unit          [3]float64 // direction of travel in axis space
+	maxSpeed      float64    // units/sec, after applying feed rate and axis limits
+	accel         float64    // units/sec^2, after applying axis limits
+	junctionSpeed float64    // max speed coming in from the previous move
+	entrySpeed    float64
+}
+
+// motionPlanner turns toolpoints into moves with a trapezoidal velocity
+// profile as they arrive, looking ahead so that the machine always has room
+// to decelerate, and slowing down for corners according to the junction
+// deviation model (as used by Grbl and others). It only keeps the moves whose
+// speeds could still be changed by moves that haven't arrived yet, which is
+// normally only back as far as the last sharp corner.
+type motionPlanner struct {
+	opt   Options
+	vel   [3]float64
+	accel [3]float64
+
+	prev     Toolpoint
+	started  bool
+	lastMove *plannedMove
+
+	pending  []plannedMove
+	entryCap float64 // max entry speed of pending[0], from the moves before it
+	flushAt  int
+
+	time float64 // seconds for the moves that are no longer pending
+}
+
+func newMotionPlanner(opt Options) *motionPlanner {
+	vel, accel := opt.axisLimits()
+	return &motionPlanner{
+		opt:      opt,
+		vel:      vel,
+		accel:    accel,
+		entryCap: math.Inf(1),
+		flushAt:  256,
+	}
+}
+
+// per-axis velocity (units/sec) and acceleration (units/sec^2) limits, for X, Y (or A), and Z
+func (opt Options) axisLimits() ([3]float64, [3]float64) {
+	unlimited := func(v float64) float64 {
+		if v <= 0 {
+			return math.Inf(1)
+		}
+		return v
+	}
+
+	vel := [3]float64{unlimited(opt.MaxVel) / 60, unlimited(opt.MaxVel) / 60, unlimited(opt.MaxVel) / 60}
+	accel := [3]float64{unlimited(opt.MaxAccel), unlimited(opt.MaxAccel), unlimited(opt.MaxAccel)}
+
+	if opt.MaxZVel > 0 {
+		vel[2] = opt.MaxZVel / 60
+	}
+	if opt.MaxZAccel > 0 {
+		accel[2] = opt.MaxZAccel
+	}
+
+	if opt.Rotary {
+		vel[1] = unlimited(opt.MaxAVel) / 60
+		accel[1] = unlimited(opt.MaxAAccel)
+	}
+
+	return vel, accel
+}
+
+// Add adds a move from the previous point to p
+func (mp *motionPlanner) Add(p Toolpoint) {
+	a := mp.prev
+	mp.prev = p
+	if !mp.started {
+		mp.started = true
+		return
+	}
+
+	d := [3]float64{p.X - a.X, p.Y - a.Y, p.Z - a.Z}
+	length := math.Sqrt(d[0]*d[0] + d[1]*d[1] + d[2]*d[2])
+	if length < 0.000001 {
+		return
+	}
+
+	m := plannedMove{
+		length:   length,
+		maxSpeed: math.Inf(1),
+		accel:    math.Inf(1),
+	}
+
+	for k := range d {
+		m.unit[k] = d[k] / length
+		if m.unit[k] != 0 {
+			m.maxSpeed = math.Min(m.maxSpeed, mp.vel[k]/math.Abs(m.unit[k]))
+			m.accel = math.Min(m.accel, mp.accel[k]/math.Abs(m.unit[k]))
+		}
+	}
+
+	feedRate := mp.opt.FeedRate(a, p)
+	if p.Feed == CuttingFeed && mp.opt.Rotary {
+		// inverse time: the move should take 1/feedRate minutes
+		m.maxSpeed = math.Min(m.maxSpeed, length*feedRate/60)
+	} else {
+		m.maxSpeed = math.Min(m.maxSpeed, feedRate/60)
+	}
+
+	// the first move starts from a standstill
+	if mp.lastMove != nil {
+		m.junctionSpeed = mp.opt.JunctionSpeed(*mp.lastMove, m)
+	}
+
+	mp.pending = append(mp.pending, m)
+	mp.lastMove = &mp.pending[len(mp.pending)-1]
+
+	if len(mp.pending) >= mp.flushAt {
+		if mp.flush(false) {
+			mp.flushAt = 256
+		} else {
+			// nothing could be finished yet, don't try again too soon
+			mp.flushAt = 2 * len(mp.pending)
+		}
+	}
+}
+
+// flush works out the speeds of the pending moves, and adds up the time for
+// the ones that can't be changed by moves that are still to come; if final,
+// the machine stops at the end of the last move. It returns false if no
+// moves could be finished.
+func (mp *motionPlanner) flush(final bool) bool {
+	n := len(mp.pending)
+	if n == 0 {
+		return false
+	}
+
+	// backward pass: make sure we can always stop by the end of the path
+	maxEntry := make([]float64, n)
+	exitSpeed := 0.0
+	for i := n - 1; i >= 0; i-- {
+		m := &mp.pending[i]
+		maxEntry[i] = math.Min(m.junctionSpeed, math.Sqrt(exitSpeed*exitSpeed+2*m.accel*m.length))
+		exitSpeed = maxEntry[i]
+	}
+
+	// if the following moves might let us go faster, we can only finish
+	// the moves up to the last one whose entry speed is limited by its own
+	// junction instead of by having to stop at the end
+	done := n
+	if !final {
+		done = 0
+		exitSpeed = math.Inf(1)
+		for i := n - 1; i > 0; i-- {
+			m := &mp.pending[i]
+			optimistic := math.Min(m.junctionSpeed, math.Sqrt(exitSpeed*exitSpeed+2*m.accel*m.length))
+			if optimistic == maxEntry[i] {
+				done = i
+				break
+			}
+			exitSpeed = optimistic
+		}
+		if done == 0 {
+			return false
+		}
+	}
+
+	// forward pass: make sure we don't accelerate harder than we can
+	entrySpeed := math.Min(maxEntry[0], mp.entryCap)
+	for i := 0; i < done; i++ {
+		m := &mp.pending[i]
+		m.entrySpeed = entrySpeed
+
+		exitSpeed := 0.0
+		if i+1 < n {
+			exitSpeed = math.Min(maxEntry[i+1], math.Sqrt(entrySpeed*entrySpeed+2*m.accel*m.length))
+		}
+		mp.time += m.Time(exitSpeed)
+		entrySpeed = exitSpeed
+	}
+
+	mp.entryCap = entrySpeed
+	mp.pending = append(mp.pending[:0], mp.pending[done:]...)
+	if len(mp.pending) > 0 {
+		mp.lastMove = &mp.pending[len(mp.pending)-1]
+	}
+
+	return true
+}
+
+// Time returns the time in seconds for all of the moves so far, if the
+// machine stops at the end of the last one
+func (mp *motionPlanner) Time() float64 {
+	final := *mp
+	final.pending = append([]plannedMove{}, mp.pending...)
+	final.flush(true)
+	return final.time
+}
+
+// JunctionSpeed returns the maximum speed at which the machine can pass from
+// move a to move b without exceeding the junction deviation
+func (opt Options) JunctionSpeed(a, b plannedMove) float64 {
+	limit := math.Min(a.maxSpeed, b.maxSpeed)
+
+	cosTheta := -(a.unit[0]*b.unit[0] + a.unit[1]*b.unit[1] + a.unit[2]*b.unit[2])
+	if cosTheta > 0.999999 {
+		// reversal: must stop
+		return 0
+	}
+	if cosTheta < -0.999999 {
+		// straight line: no slowdown
+		return limit
+	}
+
+	sinHalfTheta := math.Sqrt(0.5 * (1 - cosTheta))
+	accel := math.Min(a.accel, b.accel)
+	v := math.Sqrt(accel * opt.JunctionDeviation * sinHalfTheta / (1 - sinHalfTheta))
+
+	return math.Min(v, limit)
+}
+
+// Time returns the time in seconds to complete the move, given the speed at
+// which the next move starts
+func (m plannedMove) Time(exitSpeed float64) float64 {
+	v0 := m.entrySpeed
+	v1 := exitSpeed
+	a := m.accel
+
+	t := 0.0
+	if math.IsInf(a, 1) {
+		t = m.length / m.maxSpeed
+	} else {
+		// speed we'd reach if we accelerated for as long as possible
+		peak := math.Sqrt((2*a*m.length + v0*v0 + v1*v1) / 2)
+		if peak <= m.maxSpeed {
+			// triangular profile
+			t = (peak-v0)/a + (peak-v1)/a
+		} else {
+			// trapezoidal profile
+			accelDist := (m.maxSpeed*m.maxSpeed - v0*v0) / (2 * a)
+			decelDist := (m.maxSpeed*m.maxSpeed - v1*v1) / (2 * a)
+			cruiseDist := m.length - accelDist - decelDist
+			t = (m.maxSpeed-v0)/a + (m.maxSpeed-v1)/a + cruiseDist/m.maxSpeed
+		}
+	}
+
+	return t
+}
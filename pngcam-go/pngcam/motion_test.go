@@ -0,0 +1,86 @@
+package pngcam
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCycleTime(t *testing.T) {
+	opt := Options{
+		RapidFeed:         10000,
+		XYFeed:            3600,
+		ZFeed:             200,
+		MaxVel:            4000,
+		MaxAccel:          50,
+		JunctionDeviation: 0.01,
+	}
+
+	// long move: accelerate to 60 mm/sec over 36mm, cruise for 28mm, decelerate over 36mm
+	long := ToolpathSegment{points: []Toolpoint{{0, 0, 0, CuttingFeed}, {100, 0, 0, CuttingFeed}}}
+	checkFloat(t, "long move time", long.CycleTime(opt), 1.2+28.0/60+1.2)
+
+	// short move: never reaches full speed
+	short := ToolpathSegment{points: []Toolpoint{{0, 0, 0, CuttingFeed}, {10, 0, 0, CuttingFeed}}}
+	checkFloat(t, "short move time", short.CycleTime(opt), 2*math.Sqrt(50*10)/50)
+
+	// splitting a straight line into pieces doesn't slow it down
+	split := ToolpathSegment{points: []Toolpoint{{0, 0, 0, CuttingFeed}, {50, 0, 0, CuttingFeed}, {100, 0, 0, CuttingFeed}}}
+	checkFloat(t, "split move time", split.CycleTime(opt), long.CycleTime(opt))
+
+	// but going round a corner does
+	corner := ToolpathSegment{points: []Toolpoint{{0, 0, 0, CuttingFeed}, {50, 0, 0, CuttingFeed}, {50, 50, 0, CuttingFeed}}}
+	if corner.CycleTime(opt) <= long.CycleTime(opt) {
+		t.Errorf("cornering should be slower than a straight line: got %v vs %v", corner.CycleTime(opt), long.CycleTime(opt))
+	}
+
+	// rapids are limited by max-vel rather than the rapid feed rate
+	rapid := ToolpathSegment{points: []Toolpoint{{0, 0, 0, RapidFeed}, {1000, 0, 0, RapidFeed}}}
+	vmax := opt.MaxVel / 60
+	accelTime := vmax / opt.MaxAccel
+	accelDist := vmax * vmax / (2 * opt.MaxAccel)
+	checkFloat(t, "rapid move time", rapid.CycleTime(opt), 2*accelTime+(1000-2*accelDist)/vmax)
+
+	// higher acceleration makes it quicker
+	opt.MaxAccel = 500
+	if long.CycleTime(opt) >= 1.2+28.0/60+1.2 {
+		t.Errorf("higher acceleration should reduce cycle time, got %v", long.CycleTime(opt))
+	}
+}
+
+func TestStreamingPlanner(t *testing.T) {
+	opt := DefaultOptions()
+
+	// a long zigzag, with gentle curves and sharp corners
+	points := []Toolpoint{}
+	for i := 0; i < 5000; i++ {
+		x := float64(i) * 0.1
+		y := 10 * math.Sin(x)
+		if (i/700)%2 == 1 {
+			y = -y
+		}
+		points = append(points, Toolpoint{x, y, math.Cos(x / 3), CuttingFeed})
+	}
+
+	streaming := newMotionPlanner(opt)
+	maxPending := 0
+	for _, p := range points {
+		streaming.Add(p)
+		if len(streaming.pending) > maxPending {
+			maxPending = len(streaming.pending)
+		}
+	}
+
+	// planning the whole path in one go should give exactly the same answer
+	batch := newMotionPlanner(opt)
+	batch.flushAt = len(points) + 1
+	for _, p := range points {
+		batch.Add(p)
+	}
+
+	if streaming.Time() != batch.Time() {
+		t.Errorf("streaming planner took %v secs, expected %v", streaming.Time(), batch.Time())
+	}
+	if maxPending >= len(points)/2 {
+		t.Errorf("streaming planner kept %d of %d moves pending", maxPending, len(points))
+	}
+}
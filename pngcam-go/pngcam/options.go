@@ -0,0 +1,355 @@
+package pngcam
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Direction is the direction that the tool moves in along each pass
+type Direction int
+
+const (
+	Horizontal Direction = iota
+	Vertical
+	Helical
+)
+
+// ParseRoute converts a comma-separated list of routes into directions; "both"
+// means horizontal followed by vertical
+func ParseRoute(route string) ([]Direction, error) {
+	dirs := []Direction{}
+
+	for _, name := range strings.Split(route, ",") {
+		if name == "horizontal" {
+			dirs = append(dirs, Horizontal)
+		} else if name == "vertical" {
+			dirs = append(dirs, Vertical)
+		} else if name == "both" {
+			dirs = append(dirs, Horizontal, Vertical)
+		} else if name == "helical" {
+			dirs = append(dirs, Helical)
+		} else {
+			return nil, fmt.Errorf("unrecognised route: %s", name)
+		}
+	}
+
+	return dirs, nil
+}
+
+// Options controls everything about a Job. Distances are in mm (or inches
+// with Imperial), feed rates in mm/min, and in rotary mode Y is the angle in
+// degrees. Start from DefaultOptions() rather than a zero Options, because a
+// few of the zero values don't make sense.
+type Options struct {
+	// where to read the heightmap from, for NewJob(); any format supported
+	// by HeightmapFormatFor(), including STL
+	HeightmapPath string
+	// names of the options (as command-line flags) given explicitly, which
+	// take priority over the heightmap metadata; anything not in here may be
+	// overridden by the metadata
+	Explicit       map[string]bool
+	ReadStockPath  string
+	WriteStockPath string
+	RGB            bool    // write 24-bit colour PNG stock
+	Grey16         bool    // write 16-bit greyscale PNG stock
+	STLResolution  float64 // px/mm for rendering STL heightmaps
+
+	SafeZ     float64
+	RapidFeed float64
+	XYFeed    float64
+	ZFeed     float64
+	RPM       float64
+
+	// size of the work piece; if Width or Height is 0 it is worked out from
+	// the aspect ratio of the heightmap
+	Width  float64
+	Height float64
+	Depth  float64
+	Rotary bool
+	XFlip  bool
+	YFlip  bool
+	Invert bool
+
+	Normalise            bool
+	NormaliseIgnoreBlack bool
+
+	// routes to cut one after the other (see ParseRoute())
+	Directions []Direction
+
+	StepOver    float64
+	StepDown    float64
+	StepForward float64 // 0 means 1 pixel
+	Tolerance   float64 // for toolpath simplification
+
+	Tool Tool
+	// tool to select with a tool change at the start of the program, or 0
+	// for none
+	ToolNumber int
+	// length of the cutting part of the tool, and diameter of the shank
+	// above it; 0 if unknown
+	FluteLength   float64
+	ShankDiameter float64
+	// length of the tool sticking out of the holder, measured from the
+	// tip, and diameter of the holder; 0 if unknown
+	StickOut       float64
+	HolderDiameter float64
+	// return an error from NewJob() if the shank or holder would hit the
+	// part, instead of lifting the tool clear of it (see Job.Collisions())
+	FailOnCollision bool
+
+	Post         PostProcessor
+	ArcTolerance float64 // 0 disables arc fitting
+
+	StockToLeave float64
+
+	// work out the toolpoints for every pixel up front, on all CPUs, instead
+	// of only as the toolpath reaches them
+	Precompute bool
+	// directory to keep toolpoints maps in between runs, or "" for none
+	CacheDir string
+
+	RoughingOnly   bool
+	OmitTop        bool
+	OmitBottom     bool
+	RampEntry      bool
+	CutBelowBottom bool
+	CutBeyondEdges bool
+
+	Imperial bool
+
+	XOffset float64
+	YOffset float64
+	ZOffset float64
+
+	// machine limits, only used for cycle time estimation; 0 means the
+	// same as MaxVel/MaxAccel for Z, or unlimited for A
+	MaxVel            float64
+	MaxAccel          float64
+	MaxZVel           float64
+	MaxZAccel         float64
+	MaxAVel           float64
+	MaxAAccel         float64
+	JunctionDeviation float64
+
+	// don't write dimensions and progress to stderr (warnings are still
+	// written)
+	Quiet bool
+
+	// filled in by NewJob()
+	x_MmPerPx float64
+	y_MmPerPx float64
+	widthPx   int
+	heightPx  int
+}
+
+// DefaultOptions returns the same options that the pngcam command uses when
+// no flags are given
+func DefaultOptions() Options {
+	tool, _ := NewTool("ball", 6)
+
+	return Options{
+		Explicit:      map[string]bool{},
+		STLResolution: 10,
+
+		SafeZ:     5,
+		RapidFeed: 10000,
+		XYFeed:    400,
+		ZFeed:     50,
+		RPM:       10000,
+
+		Depth: 10,
+
+		Directions: []Direction{Horizontal},
+
+		StepOver: 5,
+		StepDown: 100,
+
+		Tool: tool,
+		Post: &LinuxCNCPost{},
+
+		MaxVel:            4000,
+		MaxAccel:          50,
+		JunctionDeviation: 0.01,
+	}
+}
+
+// FeedRate returns the feed rate for the move from start to end; rapid moves
+// always travel at the machine's rapid rate in units/min (G0 ignores G93),
+// while cutting moves in rotary mode are given in inverse time
+func (opt Options) FeedRate(start Toolpoint, end Toolpoint) float64 {
+	if end.Feed == RapidFeed {
+		return opt.RapidFeed
+	}
+
+	dx := end.X - start.X
+	dy := end.Y - start.Y
+	dz := end.Z - start.Z
+
+	xyDist := math.Sqrt(dx*dx + dy*dy)
+	zDist := dz
+
+	if opt.Rotary {
+		// TODO: this is only an approximation of the arc length (we could consider
+		// deriving the true value, or compute it in steps of a few degrees, or just
+		// leave as-is)
+		highZ := start.Z
+		if end.Z > highZ {
+			highZ = end.Z
+		}
+		arcLength := math.Pi * highZ * 2 * dy / 360.0 // circumference = pi * diameter
+		xyDist = math.Sqrt(arcLength*arcLength + dx*dx)
+	}
+
+	totalDist := math.Sqrt(xyDist*xyDist + zDist*zDist)
+
+	epsilon := 0.00001
+
+	// rapid feed on vertical upwards movement with no XY component
+	unitsPerMin := opt.RapidFeed
+	if xyDist >= epsilon || zDist < 0 {
+		if zDist >= 0 || math.Abs(xyDist/zDist) > math.Abs(opt.XYFeed/opt.ZFeed) {
+			// XY feed is limiting factor
+			unitsPerMin = opt.XYFeed
+		} else {
+			unitsPerMin = opt.ZFeed
+		}
+	}
+
+	if opt.Rotary {
+		// in rotary mode we use "inverse time" feed rates
+		if totalDist < epsilon {
+			return opt.RapidFeed // XXX: what should we do here? probably doesn't matter given that distance = 0
+		}
+		movesPerMin := unitsPerMin / totalDist
+		return movesPerMin
+	} else {
+		return unitsPerMin
+	}
+}
+
+// ApplyAspectRatio fills in whichever of width and height was not given (i.e.
+// is 0), so that the pixels of a widthPx x heightPx image are square; if
+// neither was given, the width defaults to 100. It returns false if both were
+// given and the pixels are not square.
+func (opt *Options) ApplyAspectRatio(widthPx, heightPx int) bool {
+	aspectRatio := float64(widthPx) / float64(heightPx)
+
+	if opt.Width == 0 && opt.Height == 0 {
+		opt.Width = 100
+	}
+
+	if opt.Height == 0 {
+		opt.Height = opt.Width / aspectRatio
+		return true
+	}
+	if opt.Width == 0 {
+		opt.Width = opt.Height * aspectRatio
+		return true
+	}
+
+	xMmPerPx := opt.Width / float64(widthPx)
+	yMmPerPx := opt.Height / float64(heightPx)
+	return math.Abs(xMmPerPx-yMmPerPx) <= 0.001*math.Max(xMmPerPx, yMmPerPx)
+}
+
+// ApplyMetadata uses the description of the part that pngcam-render embeds
+// in its heightmaps as defaults for the options that weren't given on the
+// command line (see Explicit); it returns a warning for each option that was
+// given but disagrees. Width and height are taken together, so that giving
+// either one still maintains the aspect ratio.
+func (opt *Options) ApplyMetadata(meta map[string]string) []string {
+	warnings := []string{}
+
+	mm := func(key string) (float64, bool) {
+		v, err := strconv.ParseFloat(meta[key], 64)
+		return v, err == nil && v > 0
+	}
+	differ := func(a, b float64) bool {
+		return math.Abs(a-b) > 0.001*math.Max(a, b)
+	}
+
+	if v, ok := meta[TextRotary]; ok {
+		rotary := v == "true"
+		if !opt.Explicit["rotary"] {
+			opt.Rotary = rotary
+		} else if rotary != opt.Rotary {
+			warnings = append(warnings, fmt.Sprintf("--rotary=%v, but heightmap was rendered with rotary=%v", opt.Rotary, rotary))
+		}
+	}
+
+	width, hasWidth := mm(TextWidth)
+	height, hasHeight := mm(TextHeight)
+	if opt.Explicit["width"] || opt.Explicit["height"] {
+		if hasWidth && opt.Explicit["width"] && differ(width, opt.Width) {
+			warnings = append(warnings, fmt.Sprintf("--width %g, but heightmap is %g wide", opt.Width, width))
+		}
+		if hasHeight && opt.Explicit["height"] && !opt.Rotary && differ(height, opt.Height) {
+			warnings = append(warnings, fmt.Sprintf("--height %g, but heightmap is %g high", opt.Height, height))
+		}
+	} else {
+		if hasWidth {
+			opt.Width = width
+		}
+		if hasHeight && !opt.Rotary {
+			opt.Height = height
+		}
+	}
+
+	if depth, ok := mm(TextDepth); ok {
+		if !opt.Explicit["depth"] {
+			opt.Depth = depth
+		} else if differ(depth, opt.Depth) {
+			warnings = append(warnings, fmt.Sprintf("--depth %g, but heightmap is %g deep", opt.Depth, depth))
+		}
+	}
+
+	return warnings
+}
+
+// XStepForward returns the distance between points along the X axis, which
+// is 1 pixel unless --step-forward was given
+func (opt Options) XStepForward() float64 {
+	if opt.StepForward > 0 {
+		return opt.StepForward
+	}
+	return opt.x_MmPerPx
+}
+
+// YStepForward returns the distance between points along the Y axis, which
+// is 1 pixel unless --step-forward was given; in rotary mode this is in degrees
+func (opt Options) YStepForward() float64 {
+	if opt.StepForward > 0 {
+		return opt.StepForward
+	}
+	return opt.y_MmPerPx
+}
+
+func (opt *Options) MmToPx(x, y float64) (int, int) {
+	xPx := int(x / opt.x_MmPerPx)
+	yPx := int(-y/opt.y_MmPerPx) + opt.heightPx - 1
+	if opt.Rotary {
+		yPx = ((yPx % opt.heightPx) + opt.heightPx) % opt.heightPx
+	}
+	return xPx, yPx
+}
+
+func (opt Options) PxToMm(x, y int) (float64, float64) {
+	xMm := float64(x) * opt.x_MmPerPx
+	yMm := float64(opt.heightPx-1-y) * opt.y_MmPerPx
+	// TODO: do we need to handle out-of-bounds pixels in rotary mode?
+	return xMm, yMm
+}
+
+// FlipPx converts pixel coordinates between the toolpath and the heightmap
+// image, according to --x-flip and --y-flip; it is its own inverse
+func (opt Options) FlipPx(x, y int) (int, int) {
+	if opt.XFlip {
+		x = opt.widthPx - 1 - x
+	}
+	if opt.YFlip {
+		y = opt.heightPx - 1 - y
+	}
+	return x, y
+}
@@ -0,0 +1,129 @@
+package pngcam
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFeedRate(t *testing.T) {
+	opt := Options{
+		SafeZ:     5,
+		RapidFeed: 10000,
+		XYFeed:    2000,
+		ZFeed:     200,
+	}
+
+	// vertical up: rapid feed
+	checkFeedRate(t, opt, 0, 0, 0, 0, 0, 10, opt.RapidFeed)
+
+	// vertical down: z feed
+	checkFeedRate(t, opt, 0, 0, 10, 0, 0, 0, opt.ZFeed)
+
+	// xy motion: xy feed
+	checkFeedRate(t, opt, 0, 0, 0, 10, 0, 0, opt.XYFeed)
+	checkFeedRate(t, opt, 10, 0, 0, 10, 10, 0, opt.XYFeed)
+
+	// shallow diagonal motion up/down: xy feed
+	checkFeedRate(t, opt, 0, 0, 0, 10, 10, 1, opt.XYFeed)
+	checkFeedRate(t, opt, 0, 0, 0, 10, 10, -1, opt.XYFeed)
+
+	// steep diagonal motion up: xyfeed
+	checkFeedRate(t, opt, 0, 0, 0, 1, 1, 10, opt.XYFeed)
+
+	// steep diagonal motion down: z feed
+	checkFeedRate(t, opt, 0, 0, 0, 1, 0, -10, opt.ZFeed)
+}
+
+func checkFeedRate(t *testing.T, opt Options, x1 float64, y1 float64, z1 float64, x2 float64, y2 float64, z2 float64, wantfeed float64) {
+	epsilon := 0.00001
+
+	feed := opt.FeedRate(Toolpoint{x1, y1, z1, CuttingFeed}, Toolpoint{x2, y2, z2, CuttingFeed})
+
+	if math.Abs(feed-wantfeed) > epsilon {
+		t.Errorf("feed rate from (%f,%f,%f) to (%f,%f,%f) should be %f, got %f", x1, y1, z1, x2, y2, z2, wantfeed, feed)
+	}
+}
+
+func TestStepForward(t *testing.T) {
+	opt := Options{x_MmPerPx: 0.1, y_MmPerPx: 0.2}
+
+	if opt.XStepForward() != 0.1 || opt.YStepForward() != 0.2 {
+		t.Errorf("default step-forward should be 1 pixel, got %v,%v", opt.XStepForward(), opt.YStepForward())
+	}
+
+	opt.StepForward = 0.5
+	if opt.XStepForward() != 0.5 || opt.YStepForward() != 0.5 {
+		t.Errorf("step-forward should be 0.5, got %v,%v", opt.XStepForward(), opt.YStepForward())
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	dirs, err := ParseRoute("both")
+	if err != nil || len(dirs) != 2 || dirs[0] != Horizontal || dirs[1] != Vertical {
+		t.Errorf("route \"both\" should be horizontal,vertical, got %v (%v)", dirs, err)
+	}
+
+	dirs, err = ParseRoute("vertical,horizontal,vertical")
+	if err != nil || len(dirs) != 3 || dirs[0] != Vertical || dirs[1] != Horizontal || dirs[2] != Vertical {
+		t.Errorf("route list should be vertical,horizontal,vertical, got %v (%v)", dirs, err)
+	}
+
+	_, err = ParseRoute("diagonal")
+	if err == nil {
+		t.Errorf("unrecognised route should be an error")
+	}
+}
+
+func TestApplyAspectRatio(t *testing.T) {
+	opt := Options{}
+	if !opt.ApplyAspectRatio(200, 100) || opt.Width != 100 || opt.Height != 50 {
+		t.Errorf("default dimensions should be 100x50, got %vx%v", opt.Width, opt.Height)
+	}
+
+	opt = Options{Height: 30}
+	if !opt.ApplyAspectRatio(200, 100) || opt.Width != 60 || opt.Height != 30 {
+		t.Errorf("dimensions from height should be 60x30, got %vx%v", opt.Width, opt.Height)
+	}
+
+	opt = Options{Width: 30, Height: 30}
+	if opt.ApplyAspectRatio(200, 100) {
+		t.Errorf("30x30 from 200x100 px should give non-square pixels")
+	}
+	if opt.Width != 30 || opt.Height != 30 {
+		t.Errorf("explicit dimensions should be kept, got %vx%v", opt.Width, opt.Height)
+	}
+}
+
+func TestApplyMetadata(t *testing.T) {
+	meta := map[string]string{
+		TextWidth:  "27.03",
+		TextHeight: "40",
+		TextDepth:  "12.5",
+		TextRotary: "false",
+		TextSide:   "bottom",
+	}
+
+	opt := Options{Depth: 10}
+	if warnings := opt.ApplyMetadata(meta); len(warnings) != 0 {
+		t.Errorf("defaults shouldn't give warnings, got %v", warnings)
+	}
+	if opt.Width != 27.03 || opt.Height != 40 || opt.Depth != 12.5 || opt.Rotary {
+		t.Errorf("dimensions should come from metadata, got %vx%vx%v (rotary=%v)", opt.Width, opt.Height, opt.Depth, opt.Rotary)
+	}
+
+	// explicit flags win, and the height is left to the aspect ratio
+	opt = Options{Width: 20, Depth: 12.5, Explicit: map[string]bool{"width": true, "depth": true}}
+	warnings := opt.ApplyMetadata(meta)
+	if len(warnings) != 1 {
+		t.Errorf("mismatched width should give 1 warning, got %v", warnings)
+	}
+	if opt.Width != 20 || opt.Height != 0 || opt.Depth != 12.5 {
+		t.Errorf("explicit dimensions should be kept, got %vx%vx%v", opt.Width, opt.Height, opt.Depth)
+	}
+
+	opt = Options{Explicit: map[string]bool{}}
+	opt.ApplyMetadata(map[string]string{TextWidth: "30", TextDepth: "8", TextRotary: "true"})
+	if !opt.Rotary || opt.Width != 30 || opt.Depth != 8 {
+		t.Errorf("rotary part should come from metadata, got %vx%v (rotary=%v)", opt.Width, opt.Depth, opt.Rotary)
+	}
+}
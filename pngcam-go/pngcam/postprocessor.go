@@ -0,0 +1,261 @@
+package pngcam
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type PostProcessor interface {
+	Preamble(opt Options) string
+	Postamble(opt Options) string
+	Rapid(opt Options, p Toolpoint) string
+	Move(opt Options, p Toolpoint, feedRate float64) string
+	Plane(plane Plane) string
+	Arc(opt Options, arc Arc, feedRate float64) string
+	Comment(text string) string
+}
+
+type LinuxCNCPost struct{}
+type GrblPost struct{}
+type FanucPost struct{}
+
+func NewPostProcessor(name string) (PostProcessor, error) {
+	if name == "linuxcnc" {
+		return &LinuxCNCPost{}, nil
+	} else if name == "grbl" {
+		return &GrblPost{}, nil
+	} else if name == "fanuc" {
+		return &FanucPost{}, nil
+	} else {
+		return nil, fmt.Errorf("unrecognised post-processor: %s", name)
+	}
+}
+
+// format a coordinate with a fixed number of decimal places
+func formatCoord(v float64, precision int) string {
+	return strconv.FormatFloat(v, 'f', precision, 64)
+}
+
+// format a feed rate with at most the given number of decimal places, and no trailing zeroes
+func formatFeed(v float64, precision int) string {
+	s := strconv.FormatFloat(v, 'f', precision, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	return s
+}
+
+// TODO: make the rotary axis name configurable
+func yAxisName(opt Options) string {
+	if opt.Rotary {
+		return "A"
+	}
+	return "Y"
+}
+
+func planeSelect(plane Plane) string {
+	if plane == PlaneXY {
+		return "G17\n"
+	} else if plane == PlaneZX {
+		return "G18\n"
+	} else {
+		return "G19\n"
+	}
+}
+
+// format the command, end point, and centre offsets of an arc, without the feed rate
+func formatArc(opt Options, arc Arc, precision int) string {
+	cmd := "G3"
+	if arc.clockwise {
+		cmd = "G2"
+	}
+
+	centre := ""
+	if arc.plane == PlaneXY {
+		centre = "I" + formatCoord(arc.i, precision) + " J" + formatCoord(arc.j, precision)
+	} else if arc.plane == PlaneZX {
+		centre = "I" + formatCoord(arc.i, precision) + " K" + formatCoord(arc.k, precision)
+	} else {
+		centre = "J" + formatCoord(arc.j, precision) + " K" + formatCoord(arc.k, precision)
+	}
+
+	pt := arc.end
+	return fmt.Sprintf("%s X%s %s%s Z%s %s", cmd, formatCoord(pt.X+opt.XOffset, precision), yAxisName(opt), formatCoord(pt.Y+opt.YOffset, precision), formatCoord(pt.Z+opt.ZOffset, precision), centre)
+}
+
+func (p *LinuxCNCPost) Preamble(opt Options) string {
+	gcode := strings.Builder{}
+
+	if opt.Imperial {
+		gcode.WriteString("G20\n") // inches
+	} else {
+		gcode.WriteString("G21\n") // mm
+	}
+	gcode.WriteString("G90\n") // absolute coordinates
+	gcode.WriteString("G54\n") // work coordinate system
+
+	if opt.Rotary {
+		// inverse time mode, because LinuxCNC's "units per minute" mode (G94) is
+		// broken for combined linear and rotary moves
+		gcode.WriteString("G93\n")
+	}
+
+	if opt.ToolNumber > 0 {
+		fmt.Fprintf(&gcode, "T%d M6\n", opt.ToolNumber)
+	}
+	fmt.Fprintf(&gcode, "M3 S%g\n", opt.RPM)
+
+	fmt.Fprintf(&gcode, "G0 Z%s\n", formatCoord(opt.SafeZ+opt.ZOffset, 4))
+
+	if opt.Rotary {
+		gcode.WriteString("G0 Y0\n")
+	}
+
+	return gcode.String()
+}
+
+func (p *LinuxCNCPost) Postamble(opt Options) string {
+	return "M5\nM2\n" // stop spindle, end program
+}
+
+func (p *LinuxCNCPost) Rapid(opt Options, pt Toolpoint) string {
+	return fmt.Sprintf("G0 X%s %s%s Z%s\n", formatCoord(pt.X+opt.XOffset, 4), yAxisName(opt), formatCoord(pt.Y+opt.YOffset, 4), formatCoord(pt.Z+opt.ZOffset, 4))
+}
+
+func (p *LinuxCNCPost) Move(opt Options, pt Toolpoint, feedRate float64) string {
+	return fmt.Sprintf("G1 X%s %s%s Z%s F%g\n", formatCoord(pt.X+opt.XOffset, 4), yAxisName(opt), formatCoord(pt.Y+opt.YOffset, 4), formatCoord(pt.Z+opt.ZOffset, 4), feedRate)
+}
+
+func (p *LinuxCNCPost) Plane(plane Plane) string {
+	return planeSelect(plane)
+}
+
+func (p *LinuxCNCPost) Arc(opt Options, arc Arc, feedRate float64) string {
+	return fmt.Sprintf("%s F%g\n", formatArc(opt, arc, 4), feedRate)
+}
+
+// LinuxCNC comments can't be nested
+func (p *LinuxCNCPost) Comment(text string) string {
+	text = strings.ReplaceAll(text, "(", "")
+	text = strings.ReplaceAll(text, ")", "")
+	return "(" + text + ")\n"
+}
+
+func (p *GrblPost) Preamble(opt Options) string {
+	gcode := strings.Builder{}
+
+	gcode.WriteString(p.Comment("pngcam"))
+
+	if opt.Imperial {
+		gcode.WriteString("G20\n")
+	} else {
+		gcode.WriteString("G21\n")
+	}
+	gcode.WriteString("G90\n")
+	gcode.WriteString("G54\n")
+
+	if opt.Rotary {
+		gcode.WriteString("G93\n")
+	}
+
+	if opt.ToolNumber > 0 {
+		// Grbl doesn't do tool changes
+		gcode.WriteString(p.Comment(fmt.Sprintf("tool %d", opt.ToolNumber)))
+	}
+	fmt.Fprintf(&gcode, "M3 S%.0f\n", opt.RPM)
+
+	fmt.Fprintf(&gcode, "G0 Z%s\n", formatCoord(opt.SafeZ+opt.ZOffset, 3))
+
+	if opt.Rotary {
+		gcode.WriteString("G0 A0\n")
+	}
+
+	return gcode.String()
+}
+
+func (p *GrblPost) Postamble(opt Options) string {
+	return "M5\nM2\n"
+}
+
+func (p *GrblPost) Rapid(opt Options, pt Toolpoint) string {
+	return fmt.Sprintf("G0 X%s %s%s Z%s\n", formatCoord(pt.X+opt.XOffset, 3), yAxisName(opt), formatCoord(pt.Y+opt.YOffset, 3), formatCoord(pt.Z+opt.ZOffset, 3))
+}
+
+func (p *GrblPost) Move(opt Options, pt Toolpoint, feedRate float64) string {
+	return fmt.Sprintf("G1 X%s %s%s Z%s F%s\n", formatCoord(pt.X+opt.XOffset, 3), yAxisName(opt), formatCoord(pt.Y+opt.YOffset, 3), formatCoord(pt.Z+opt.ZOffset, 3), formatFeed(feedRate, 3))
+}
+
+func (p *GrblPost) Plane(plane Plane) string {
+	return planeSelect(plane)
+}
+
+func (p *GrblPost) Arc(opt Options, arc Arc, feedRate float64) string {
+	return fmt.Sprintf("%s F%s\n", formatArc(opt, arc, 3), formatFeed(feedRate, 3))
+}
+
+// Grbl discards everything after a semicolon, which is simpler than trying to
+// escape parentheses
+func (p *GrblPost) Comment(text string) string {
+	return "; " + strings.ReplaceAll(text, "\n", " ") + "\n"
+}
+
+func (p *FanucPost) Preamble(opt Options) string {
+	gcode := strings.Builder{}
+
+	gcode.WriteString("%\n")
+	gcode.WriteString("O0001 " + p.Comment("PNGCAM"))
+	gcode.WriteString("G17 G40 G49 G80\n") // XY plane, cancel cutter compensation, tool length offset, canned cycles
+
+	if opt.Imperial {
+		gcode.WriteString("G20\n")
+	} else {
+		gcode.WriteString("G21\n")
+	}
+	gcode.WriteString("G90 G54\n")
+
+	if opt.Rotary {
+		gcode.WriteString("G93\n")
+	}
+
+	if opt.ToolNumber > 0 {
+		fmt.Fprintf(&gcode, "T%d M6\n", opt.ToolNumber)
+	}
+	fmt.Fprintf(&gcode, "S%.0f M3\n", opt.RPM)
+
+	fmt.Fprintf(&gcode, "G0 Z%s\n", formatCoord(opt.SafeZ+opt.ZOffset, 3))
+
+	if opt.Rotary {
+		gcode.WriteString("G0 A0.\n")
+	}
+
+	return gcode.String()
+}
+
+func (p *FanucPost) Postamble(opt Options) string {
+	return "M5\nM30\n%\n"
+}
+
+func (p *FanucPost) Rapid(opt Options, pt Toolpoint) string {
+	return fmt.Sprintf("G0 X%s %s%s Z%s\n", formatCoord(pt.X+opt.XOffset, 3), yAxisName(opt), formatCoord(pt.Y+opt.YOffset, 3), formatCoord(pt.Z+opt.ZOffset, 3))
+}
+
+func (p *FanucPost) Move(opt Options, pt Toolpoint, feedRate float64) string {
+	return fmt.Sprintf("G1 X%s %s%s Z%s F%s\n", formatCoord(pt.X+opt.XOffset, 3), yAxisName(opt), formatCoord(pt.Y+opt.YOffset, 3), formatCoord(pt.Z+opt.ZOffset, 3), formatFeed(feedRate, 3))
+}
+
+func (p *FanucPost) Plane(plane Plane) string {
+	return planeSelect(plane)
+}
+
+func (p *FanucPost) Arc(opt Options, arc Arc, feedRate float64) string {
+	return fmt.Sprintf("%s F%s\n", formatArc(opt, arc, 3), formatFeed(feedRate, 3))
+}
+
+// Fanuc controls only accept upper case comments, and they can't be nested
+func (p *FanucPost) Comment(text string) string {
+	text = strings.ReplaceAll(text, "(", "")
+	text = strings.ReplaceAll(text, ")", "")
+	return "(" + strings.ToUpper(text) + ")\n"
+}
@@ -0,0 +1,73 @@
+package pngcam
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostProcessors(t *testing.T) {
+	opt := Options{
+		SafeZ:     5,
+		RapidFeed: 10000,
+		RPM:       10000,
+	}
+
+	for _, name := range []string{"linuxcnc", "grbl", "fanuc"} {
+		post, err := NewPostProcessor(name)
+		if err != nil {
+			t.Fatalf("can't create %s post-processor: %v", name, err)
+		}
+
+		if !strings.Contains(post.Preamble(opt), "G21") {
+			t.Errorf("%s preamble should select mm units", name)
+		}
+		if !strings.Contains(post.Postamble(opt), "M5") {
+			t.Errorf("%s postamble should stop the spindle", name)
+		}
+		if strings.Contains(post.Preamble(opt), "T") {
+			t.Errorf("%s preamble shouldn't change tool without a tool number", name)
+		}
+
+		toolOpt := opt
+		toolOpt.ToolNumber = 3
+		if name != "grbl" && !strings.Contains(post.Preamble(toolOpt), "T3 M6\n") {
+			t.Errorf("%s preamble should change to tool 3", name)
+		}
+	}
+
+	_, err := NewPostProcessor("bogus")
+	if err == nil {
+		t.Errorf("unrecognised post-processor should be an error")
+	}
+}
+
+func TestLinuxCNCMoves(t *testing.T) {
+	opt := Options{RapidFeed: 10000}
+	post := &LinuxCNCPost{}
+
+	got := post.Move(opt, Toolpoint{1, 2, -3, CuttingFeed}, 400)
+	want := "G1 X1.0000 Y2.0000 Z-3.0000 F400\n"
+	if got != want {
+		t.Errorf("linuxcnc move: expected %q, got %q", want, got)
+	}
+
+	opt.Rotary = true
+	got = post.Rapid(opt, Toolpoint{1, 90, 5, RapidFeed})
+	want = "G0 X1.0000 A90.0000 Z5.0000\n"
+	if got != want {
+		t.Errorf("linuxcnc rotary rapid: expected %q, got %q", want, got)
+	}
+}
+
+func TestComments(t *testing.T) {
+	checkComment(t, &LinuxCNCPost{}, "hello (world)", "(hello world)\n")
+	checkComment(t, &GrblPost{}, "hello", "; hello\n")
+	checkComment(t, &FanucPost{}, "hello (world)", "(HELLO WORLD)\n")
+}
+
+func checkComment(t *testing.T, post PostProcessor, text string, want string) {
+	got := post.Comment(text)
+	if got != want {
+		t.Errorf("comment %q should be %q, got %q", text, want, got)
+	}
+}
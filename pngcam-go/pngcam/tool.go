@@ -0,0 +1,352 @@
+package pngcam
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Tool interface {
+	Radius() float64
+	HeightAtRadius(float64) float64
+	HeightAtRadiusSqr(float64) float64
+	LengthToIntersection(float64, float64, float64) float64
+}
+
+type BallEndMill struct{ radius float64 }
+type FlatEndMill struct{ radius float64 }
+type VBit struct {
+	radius    float64
+	angle     float64 // included angle in degrees
+	tipRadius float64 // radius of the flat at the tip, 0 for a sharp point
+}
+type BullNoseEndMill struct {
+	radius       float64
+	cornerRadius float64
+}
+type TaperedBallEndMill struct {
+	radius    float64 // of the shank
+	tipRadius float64 // of the ball
+	angle     float64 // between the side and the axis, in degrees
+}
+
+func NewTool(tooltype string, diameter float64) (Tool, error) {
+	if tooltype == "flat" {
+		return &FlatEndMill{radius: diameter / 2}, nil
+	} else if tooltype == "ball" {
+		return &BallEndMill{radius: diameter / 2}, nil
+	} else if strings.HasPrefix(tooltype, "vbit") {
+		// vbitANGLE, or vbitANGLEtipDIAMETER for a flat tip
+		angleStr, tipStr, hasTip := strings.Cut(tooltype[4:], "tip")
+		angle, err := strconv.ParseFloat(angleStr, 64)
+		if err != nil {
+			return nil, err
+		}
+		if !(angle > 0 && angle < 180) {
+			return nil, fmt.Errorf("V-bit included angle %g must be more than 0 and less than 180 degrees", angle)
+		}
+		tipDiameter := 0.0
+		if hasTip {
+			tipDiameter, err = strconv.ParseFloat(tipStr, 64)
+			if err != nil {
+				return nil, err
+			}
+			if tipDiameter < 0 || tipDiameter >= diameter {
+				return nil, fmt.Errorf("V-bit tip diameter %g must be at least 0 and less than the tool diameter %g", tipDiameter, diameter)
+			}
+		}
+		return &VBit{radius: diameter / 2, angle: angle, tipRadius: tipDiameter / 2}, nil
+	} else if strings.HasPrefix(tooltype, "bull") {
+		cornerRadius, err := strconv.ParseFloat(tooltype[4:], 64)
+		if err != nil {
+			return nil, err
+		}
+		if cornerRadius < 0 || cornerRadius > diameter/2 {
+			return nil, fmt.Errorf("bull-nose corner radius %g must be between 0 and the tool radius %g", cornerRadius, diameter/2)
+		}
+		return &BullNoseEndMill{radius: diameter / 2, cornerRadius: cornerRadius}, nil
+	} else if strings.HasPrefix(tooltype, "taperball") {
+		// taperballANGLEtipDIAMETER
+		angleStr, tipStr, hasTip := strings.Cut(tooltype[9:], "tip")
+		if !hasTip {
+			return nil, fmt.Errorf("tapered ball-nose needs a tip diameter, e.g. taperball3.6tip0.5")
+		}
+		angle, err := strconv.ParseFloat(angleStr, 64)
+		if err != nil {
+			return nil, err
+		}
+		if !(angle > 0 && angle < 90) {
+			return nil, fmt.Errorf("tapered ball-nose angle %g must be more than 0 and less than 90 degrees", angle)
+		}
+		tipDiameter, err := strconv.ParseFloat(tipStr, 64)
+		if err != nil {
+			return nil, err
+		}
+		if !(tipDiameter > 0 && tipDiameter <= diameter) {
+			return nil, fmt.Errorf("tapered ball-nose tip diameter %g must be more than 0 and no more than the tool diameter %g", tipDiameter, diameter)
+		}
+		return &TaperedBallEndMill{radius: diameter / 2, tipRadius: tipDiameter / 2, angle: angle}, nil
+	} else {
+		return nil, fmt.Errorf("unrecognised tool type: %s", tooltype)
+	}
+}
+
+func (t *BallEndMill) Radius() float64        { return t.radius }
+func (t *FlatEndMill) Radius() float64        { return t.radius }
+func (t *VBit) Radius() float64               { return t.radius }
+func (t *BullNoseEndMill) Radius() float64    { return t.radius }
+func (t *TaperedBallEndMill) Radius() float64 { return t.radius }
+
+func (t *BallEndMill) HeightAtRadius(r float64) float64 {
+	return t.HeightAtRadiusSqr(r * r)
+}
+func (t *BallEndMill) HeightAtRadiusSqr(rSqr float64) float64 {
+	if rSqr > t.radius*t.radius {
+		return math.Inf(1)
+	}
+
+	return t.radius - math.Sqrt(t.radius*t.radius-rSqr)
+}
+func (t *BallEndMill) LengthToIntersection(xOffset float64, angle float64, z float64) float64 {
+	// how much does the tool radius shrink by due to the distance from centre line in x axis?
+	radiusChange := t.radius - math.Sqrt(t.radius*t.radius-xOffset*xOffset)
+
+	// what is the length of the line segment from the origin, at angle "angle", that intersects with a circle whose bottom is at z=z?
+	// sine law: a/sinA = b/sinB = c/sinC
+	// we have a triange with angle A = abs(angle), side length a = tool.radius, side length b = z+tool.radius
+	A := math.Abs(angle * math.Pi / 180.0)
+	a := t.radius - radiusChange
+	b := z + t.radius + radiusChange
+	// we want to know side length c
+	// first use the sine law to find angle B
+	// b/sinB = a/sinA, sinB = b.sin(A)/a, B = asin(b.sin(A)/a)
+	B := math.Asin(b * math.Sin(A) / a)
+	if math.IsNaN(B) {
+		// tool can not touch workpiece at this angle
+		return math.NaN()
+	}
+	B = math.Pi - B // of the 2 possible solutions, we want the larger angle for B
+	// now we know that the angles add up to 180 degrees (pi radians), find angle C
+	C := math.Pi - (B + A)
+	// now use the sine law to find side length c
+	// c/sinC = a/sinA, c = a.sin(C)/sin(A)
+	c := a * math.Sin(C) / math.Sin(A)
+
+	return c
+}
+
+func (t *FlatEndMill) HeightAtRadius(r float64) float64 {
+	return t.HeightAtRadiusSqr(r * r)
+}
+func (t *FlatEndMill) HeightAtRadiusSqr(rSqr float64) float64 {
+	if rSqr > t.radius*t.radius {
+		return math.Inf(1)
+	}
+
+	return 0
+}
+func (t *FlatEndMill) LengthToIntersection(xOffset float64, angle float64, z float64) float64 {
+	h := z / math.Cos(angle*math.Pi/180.0)
+	yOffset := h * math.Sin(angle*math.Pi/180.0)
+	rSqr := xOffset*xOffset + yOffset*yOffset
+	if rSqr > t.radius*t.radius {
+		return math.NaN()
+	}
+	return h
+}
+
+func (t *VBit) HeightAtRadius(r float64) float64 {
+	if r > t.radius {
+		return math.Inf(1)
+	}
+	if r <= t.tipRadius {
+		return 0
+	}
+
+	return (r - t.tipRadius) / math.Tan((t.angle/2)*math.Pi/180)
+}
+func (t *VBit) HeightAtRadiusSqr(rSqr float64) float64 {
+	return t.HeightAtRadius(math.Sqrt(rSqr))
+}
+func (t *VBit) LengthToIntersection(xOffset float64, angle float64, z float64) float64 {
+	if math.Abs(xOffset) > t.radius {
+		return math.NaN()
+	}
+
+	sin := math.Sin(angle * math.Pi / 180.0)
+	cos := math.Cos(angle * math.Pi / 180.0)
+	if cos <= 0 {
+		return math.NaN()
+	}
+
+	// the line can only reach the tool from below, because it is moving
+	// away from the tool's axis, so it either hits the flat tip...
+	h := z / cos
+	yOffset := h * sin
+	rSqr := xOffset*xOffset + yOffset*yOffset
+	if rSqr <= t.tipRadius*t.tipRadius {
+		return h
+	}
+
+	// ...or the cone, where the point at length l along the line has
+	// (l.cos - z).tan(angle/2) + tipRadius = sqrt(xOffset^2 + (l.sin)^2);
+	// squaring gives a quadratic in l
+	k := math.Tan((t.angle / 2) * math.Pi / 180)
+	c0 := t.tipRadius - k*z
+	a := k*k*cos*cos - sin*sin
+	b := 2 * k * cos * c0
+	c := c0*c0 - xOffset*xOffset
+
+	roots := []float64{}
+	if math.Abs(a) < 1e-12 {
+		if b != 0 {
+			roots = append(roots, -c/b)
+		}
+	} else if disc := b*b - 4*a*c; disc >= 0 {
+		q := math.Sqrt(disc)
+		roots = append(roots, (-b-q)/(2*a), (-b+q)/(2*a))
+	}
+
+	best := math.NaN()
+	for _, l := range roots {
+		y := l * sin
+		r := math.Sqrt(xOffset*xOffset + y*y)
+		// squaring also let in points on the upside-down cone, below z
+		epsilon := 0.000000001
+		if l < 0 || l*cos < z-epsilon || r < t.tipRadius-epsilon || r > t.radius+epsilon {
+			continue
+		}
+		if math.IsNaN(best) || l < best {
+			best = l
+		}
+	}
+
+	return best
+}
+
+func (t *BullNoseEndMill) HeightAtRadius(r float64) float64 {
+	if r > t.radius {
+		return math.Inf(1)
+	}
+
+	// flat in the middle, and a quarter circle of cornerRadius round the edge
+	flatRadius := t.radius - t.cornerRadius
+	if r <= flatRadius {
+		return 0
+	}
+	dr := r - flatRadius
+	return t.cornerRadius - math.Sqrt(math.Max(0, t.cornerRadius*t.cornerRadius-dr*dr))
+}
+func (t *BullNoseEndMill) HeightAtRadiusSqr(rSqr float64) float64 {
+	return t.HeightAtRadius(math.Sqrt(rSqr))
+}
+func (t *BullNoseEndMill) LengthToIntersection(xOffset float64, angle float64, z float64) float64 {
+	return convexLengthToIntersection(t, xOffset, angle, z)
+}
+
+func (t *TaperedBallEndMill) HeightAtRadius(r float64) float64 {
+	if r > t.radius {
+		return math.Inf(1)
+	}
+
+	// the cone meets the ball where its side is at angle to the axis, and
+	// carries on straight from there
+	angle := t.angle * math.Pi / 180
+	tangentRadius := t.tipRadius * math.Cos(angle)
+	if r <= tangentRadius {
+		return t.tipRadius - math.Sqrt(t.tipRadius*t.tipRadius-r*r)
+	}
+	tangentHeight := t.tipRadius - t.tipRadius*math.Sin(angle)
+	return tangentHeight + (r-tangentRadius)/math.Tan(angle)
+}
+func (t *TaperedBallEndMill) HeightAtRadiusSqr(rSqr float64) float64 {
+	return t.HeightAtRadius(math.Sqrt(rSqr))
+}
+func (t *TaperedBallEndMill) LengthToIntersection(xOffset float64, angle float64, z float64) float64 {
+	return convexLengthToIntersection(t, xOffset, angle, z)
+}
+
+// convexLengthToIntersection works out LengthToIntersection() for any tool
+// whose profile is convex (i.e. HeightAtRadius() never curves downwards),
+// numerically: the tool tip is at z on the line through the origin at angle
+// 0, and we want the distance from the origin, along a line at the given
+// angle (in degrees) and offset xOffset along the rotary axis, to the first
+// point that is inside the tool. It returns NaN if the line misses the tool.
+func convexLengthToIntersection(t Tool, xOffset float64, angle float64, z float64) float64 {
+	r := t.Radius()
+	if math.Abs(xOffset) > r {
+		return math.NaN()
+	}
+
+	sin := math.Sin(angle * math.Pi / 180.0)
+	cos := math.Cos(angle * math.Pi / 180.0)
+	if cos <= 0 {
+		return math.NaN()
+	}
+
+	// how far the point at length l is above the bottom of the tool; this
+	// is concave in l because the tool is convex, and where it is positive
+	// the point is inside the tool
+	inside := func(l float64) float64 {
+		y := l * sin
+		return l*cos - z - t.HeightAtRadiusSqr(xOffset*xOffset+y*y)
+	}
+
+	if inside(0) >= 0 {
+		return 0
+	}
+
+	// the bottom of the tool is at z, so the line can't reach it before
+	// lFlat, and if the line is too far round by then it misses
+	lFlat := math.Max(0, z/cos)
+	yFlat := lFlat * sin
+	if xOffset*xOffset+yFlat*yFlat > r*r {
+		return math.NaN()
+	}
+	if inside(lFlat) >= 0 {
+		return lFlat
+	}
+
+	// the line leaves the side of the tool at lOut, and everything on it
+	// above the top of the curved part of the tool, but inside the side, is
+	// inside the tool
+	lOut := math.Inf(1)
+	if sin != 0 {
+		lOut = math.Sqrt(r*r-xOffset*xOffset) / math.Abs(sin)
+	}
+	lIn := (z + t.HeightAtRadius(r)) / cos
+
+	if lIn > lOut {
+		// the line might only clip the curved part of the tool, so find
+		// the point furthest inside, by golden section search
+		lo, hi := lFlat, lOut
+		phi := (math.Sqrt(5) - 1) / 2
+		for i := 0; i < 64; i++ {
+			a := hi - phi*(hi-lo)
+			b := lo + phi*(hi-lo)
+			if inside(a) < inside(b) {
+				lo = a
+			} else {
+				hi = b
+			}
+		}
+		lIn = (lo + hi) / 2
+		if inside(lIn) < 0 {
+			return math.NaN()
+		}
+	}
+
+	// and then bisect for the edge of the tool
+	lo, hi := lFlat, lIn
+	for i := 0; i < 64; i++ {
+		mid := (lo + hi) / 2
+		if inside(mid) >= 0 {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	return hi
+}
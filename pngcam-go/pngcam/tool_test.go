@@ -0,0 +1,162 @@
+package pngcam
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTool(t *testing.T) {
+	tool, err := NewTool("ball", 10)
+	if err != nil {
+		t.Errorf("can't create ball tool: %v", err)
+	}
+
+	if tool.Radius() != 5 {
+		t.Errorf("tool radius was %v, expected 5", tool.Radius())
+	}
+
+	checkHeightAtRadius(t, tool, 0, 0)
+	checkHeightAtRadius(t, tool, 5, 5)
+	checkHeightAtRadius(t, tool, 1, 0.1010205)
+	checkHeightAtRadius(t, tool, 3, 1)
+	checkHeightAtRadius(t, tool, 6, math.Inf(1))
+
+	tool, err = NewTool("flat", 10)
+	if err != nil {
+		t.Errorf("can't create flat tool: %v", err)
+	}
+
+	if tool.Radius() != 5 {
+		t.Errorf("tool radius was %v, expected 5", tool.Radius())
+	}
+
+	checkHeightAtRadius(t, tool, 0, 0)
+	checkHeightAtRadius(t, tool, 5, 0)
+	checkHeightAtRadius(t, tool, 1, 0)
+	checkHeightAtRadius(t, tool, 3, 0)
+	checkHeightAtRadius(t, tool, 6, math.Inf(1))
+}
+
+func checkHeightAtRadius(t *testing.T, tool Tool, r float64, wantheight float64) {
+	epsilon := 0.00001
+
+	h := tool.HeightAtRadius(r)
+
+	if math.Abs(h-wantheight) > epsilon {
+		t.Errorf("height at radius %v should be %v, got %v", r, wantheight, h)
+	}
+}
+
+func TestBullNose(t *testing.T) {
+	tool, err := NewTool("bull1.0", 6)
+	if err != nil {
+		t.Fatalf("can't create bull-nose tool: %v", err)
+	}
+
+	if tool.Radius() != 3 {
+		t.Errorf("tool radius was %v, expected 3", tool.Radius())
+	}
+
+	checkHeightAtRadius(t, tool, 0, 0)
+	checkHeightAtRadius(t, tool, 2, 0)
+	checkHeightAtRadius(t, tool, 2.5, 1-math.Sqrt(0.75))
+	checkHeightAtRadius(t, tool, 3, 1)
+	checkHeightAtRadius(t, tool, 4, math.Inf(1))
+
+	_, err = NewTool("bull4", 6)
+	if err == nil {
+		t.Errorf("corner radius bigger than the tool should be an error")
+	}
+
+	// straight down, it's the same as the tool profile
+	checkFloat(t, "length at 0 degrees", tool.LengthToIntersection(0, 0, 10), 10)
+	checkFloat(t, "length at 0 degrees, 2.5mm along", tool.LengthToIntersection(2.5, 0, 10), 10+1-math.Sqrt(0.75))
+
+	// with no corner radius it's a flat end mill, and with the full radius
+	// it's a ball
+	flatBull, _ := NewTool("bull0", 6)
+	flat, _ := NewTool("flat", 6)
+	ballBull, _ := NewTool("bull3", 6)
+	for _, x := range []float64{0, 1, 2.9} {
+		for _, angle := range []float64{-10, 0, 5, 15} {
+			checkFloat(t, "flat bull-nose length", flatBull.LengthToIntersection(x, angle, 10), flat.LengthToIntersection(x, angle, 10))
+
+			// ball of radius 3 with its centre 13mm from the origin
+			sin := math.Sin(angle * math.Pi / 180)
+			cos := math.Cos(angle * math.Pi / 180)
+			c := 13.0
+			want := c*cos - math.Sqrt(9-x*x-c*c*sin*sin)
+			checkFloat(t, "ball bull-nose length", ballBull.LengthToIntersection(x, angle, 10), want)
+		}
+	}
+
+	// far enough round, the line misses the tool
+	if !math.IsNaN(tool.LengthToIntersection(0, 30, 10)) {
+		t.Errorf("line at 30 degrees should miss the tool, got %v", tool.LengthToIntersection(0, 30, 10))
+	}
+}
+
+func TestVBit(t *testing.T) {
+	tool, err := NewTool("vbit90", 6)
+	if err != nil {
+		t.Fatalf("can't create V-bit: %v", err)
+	}
+	checkHeightAtRadius(t, tool, 0, 0)
+	checkHeightAtRadius(t, tool, 2, 2)
+	checkHeightAtRadius(t, tool, 4, math.Inf(1))
+
+	tipped, err := NewTool("vbit60tip1", 6)
+	if err != nil {
+		t.Fatalf("can't create flat-tipped V-bit: %v", err)
+	}
+	checkHeightAtRadius(t, tipped, 0.5, 0)
+	checkHeightAtRadius(t, tipped, 1.5, 1/math.Tan(math.Pi/6))
+
+	for _, bad := range []string{"vbit0", "vbit180", "vbit-30", "vbitx", "vbit60tip6", "vbit60tip-1"} {
+		if _, err := NewTool(bad, 6); err == nil {
+			t.Errorf("%s should be an error", bad)
+		}
+	}
+
+	// the cone is convex, so the general method gives the same answer
+	for _, tool := range []Tool{tool, tipped} {
+		for _, x := range []float64{0, 0.3, 1, 2.5} {
+			for _, angle := range []float64{-12, -3, 0, 1, 8, 20} {
+				want := convexLengthToIntersection(tool, x, angle, 10)
+				got := tool.LengthToIntersection(x, angle, 10)
+				if math.IsNaN(want) != math.IsNaN(got) || math.Abs(got-want) > 0.00001 {
+					t.Errorf("%#v: length at %v mm, %v degrees should be %v, got %v", tool, x, angle, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestTaperedBall(t *testing.T) {
+	tool, err := NewTool("taperball10tip1", 6)
+	if err != nil {
+		t.Fatalf("can't create tapered ball-nose: %v", err)
+	}
+
+	// ball at the tip, meeting the cone where the slope matches
+	angle := 10 * math.Pi / 180
+	tangentRadius := 0.5 * math.Cos(angle)
+	tangentHeight := 0.5 - 0.5*math.Sin(angle)
+	checkHeightAtRadius(t, tool, 0, 0)
+	checkHeightAtRadius(t, tool, 0.3, 0.5-0.4)
+	checkHeightAtRadius(t, tool, tangentRadius, tangentHeight)
+	checkHeightAtRadius(t, tool, 3, tangentHeight+(3-tangentRadius)/math.Tan(angle))
+	checkHeightAtRadius(t, tool, 3.1, math.Inf(1))
+
+	ballSlope := (tool.HeightAtRadius(tangentRadius) - tool.HeightAtRadius(tangentRadius-0.00000001)) / 0.00000001
+	checkFloat(t, "slope of ball where it meets cone", ballSlope, 1/math.Tan(angle))
+
+	for _, bad := range []string{"taperball10", "taperball0tip1", "taperball90tip1", "taperball10tip0", "taperball10tip7"} {
+		if _, err := NewTool(bad, 6); err == nil {
+			t.Errorf("%s should be an error", bad)
+		}
+	}
+
+	checkFloat(t, "length at 0 degrees", tool.LengthToIntersection(0, 0, 10), 10)
+	checkFloat(t, "length at 0 degrees, 2mm along", tool.LengthToIntersection(2, 0, 10), 10+tool.HeightAtRadius(2))
+}
@@ -0,0 +1,176 @@
+package pngcam
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ToolLibrary is a set of named tools, read from a JSON file like:
+//
+//	{
+//	  "6mm-ball": {
+//	    "number": 1,
+//	    "shape": "ball",
+//	    "diameter": 6,
+//	    "flute_length": 22,
+//	    "shank_diameter": 6,
+//	    "rpm": 16000,
+//	    "xy_feed_rate": 800,
+//	    "z_feed_rate": 200,
+//	    "step_over": 1,
+//	    "step_down": 3
+//	  },
+//	  "4mm-bull": { "shape": "bull", "diameter": 4, "corner_radius": 0.5 }
+//	}
+type ToolLibrary map[string]*ToolLibraryEntry
+
+// ToolLibraryEntry is one tool in a ToolLibrary. The tool itself needs a
+// shape and diameter, or a profile; anything else left out (or 0) keeps its
+// usual default.
+type ToolLibraryEntry struct {
+	// tool number for the tool change at the start of the program, or 0
+	// for no tool change
+	Number int `json:"number"`
+
+	// anything accepted by NewTool(), or "bull", "vbit", or "taperball" with
+	// the dimensions given separately below
+	Shape        string  `json:"shape"`
+	Diameter     float64 `json:"diameter"`
+	CornerRadius float64 `json:"corner_radius"` // bull
+	Angle        float64 `json:"angle"`         // vbit (included), taperball (side to axis)
+	TipDiameter  float64 `json:"tip_diameter"`  // vbit, taperball
+	// CSV file for LoadToolProfile(), instead of Shape and Diameter;
+	// relative paths are relative to the library file
+	Profile string `json:"profile"`
+
+	FluteLength   float64 `json:"flute_length"`
+	ShankDiameter float64 `json:"shank_diameter"`
+
+	RPM      float64 `json:"rpm"`
+	XYFeed   float64 `json:"xy_feed_rate"`
+	ZFeed    float64 `json:"z_feed_rate"`
+	StepOver float64 `json:"step_over"`
+	StepDown float64 `json:"step_down"`
+}
+
+// DefaultToolLibraryPath is where the pngcam command looks for the tool
+// library if it isn't told otherwise: pngcam/tools.json in the user's config
+// directory
+func DefaultToolLibraryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "tools.json"
+	}
+	return filepath.Join(dir, "pngcam", "tools.json")
+}
+
+// LoadToolLibrary reads a tool library from a JSON file; the tools are only
+// checked when they are used
+func LoadToolLibrary(path string) (ToolLibrary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lib := ToolLibrary{}
+	dec := json.NewDecoder(f)
+	// so that a misspelt field isn't silently ignored
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&lib); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for name, e := range lib {
+		if e == nil {
+			return nil, fmt.Errorf("%s: tool %s is null", path, name)
+		}
+		if e.Profile != "" && !filepath.IsAbs(e.Profile) {
+			e.Profile = filepath.Join(filepath.Dir(path), e.Profile)
+		}
+	}
+
+	return lib, nil
+}
+
+// Get returns the named tool
+func (lib ToolLibrary) Get(name string) (*ToolLibraryEntry, error) {
+	e, ok := lib[name]
+	if !ok {
+		names := []string{}
+		for n := range lib {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unrecognised tool: %s (the library has: %s)", name, strings.Join(names, ", "))
+	}
+	return e, nil
+}
+
+// ToolShape returns the entry's shape in the syntax of NewTool()
+func (e *ToolLibraryEntry) ToolShape() string {
+	g := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+
+	if e.Shape == "bull" {
+		return "bull" + g(e.CornerRadius)
+	} else if e.Shape == "vbit" && e.TipDiameter > 0 {
+		return "vbit" + g(e.Angle) + "tip" + g(e.TipDiameter)
+	} else if e.Shape == "vbit" {
+		return "vbit" + g(e.Angle)
+	} else if e.Shape == "taperball" {
+		return "taperball" + g(e.Angle) + "tip" + g(e.TipDiameter)
+	} else {
+		return e.Shape
+	}
+}
+
+// NewTool makes the entry's tool
+func (e *ToolLibraryEntry) NewTool() (Tool, error) {
+	if e.Profile != "" {
+		return LoadToolProfile(e.Profile)
+	}
+	if e.Shape == "" {
+		return nil, fmt.Errorf("tool library entry needs a shape or a profile")
+	}
+	if !(e.Diameter > 0) {
+		return nil, fmt.Errorf("tool library entry with shape %s needs a diameter", e.Shape)
+	}
+	return NewTool(e.ToolShape(), e.Diameter)
+}
+
+// Apply sets the tool and its cutting data in opt, except for anything that
+// opt.Explicit says was given explicitly; the tool itself is left alone if
+// any of tool-shape, tool-diameter, or tool-profile was given
+func (e *ToolLibraryEntry) Apply(opt *Options) error {
+	if !opt.Explicit["tool-shape"] && !opt.Explicit["tool-diameter"] && !opt.Explicit["tool-profile"] {
+		tool, err := e.NewTool()
+		if err != nil {
+			return err
+		}
+		opt.Tool = tool
+	}
+
+	set := func(flag string, dst *float64, val float64) {
+		if val != 0 && !opt.Explicit[flag] {
+			*dst = val
+		}
+	}
+	set("speed", &opt.RPM, e.RPM)
+	set("xy-feed-rate", &opt.XYFeed, e.XYFeed)
+	set("z-feed-rate", &opt.ZFeed, e.ZFeed)
+	set("step-over", &opt.StepOver, e.StepOver)
+	set("step-down", &opt.StepDown, e.StepDown)
+	set("flute-length", &opt.FluteLength, e.FluteLength)
+	set("shank-diameter", &opt.ShankDiameter, e.ShankDiameter)
+
+	if e.Number != 0 && !opt.Explicit["tool-number"] {
+		opt.ToolNumber = e.Number
+	}
+
+	return nil
+}
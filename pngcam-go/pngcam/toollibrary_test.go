@@ -0,0 +1,104 @@
+package pngcam
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToolLibrary(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tools.json")
+	json := `{
+		"bull": {"number": 3, "shape": "bull", "diameter": 4, "corner_radius": 0.5, "flute_length": 12, "shank_diameter": 6,
+			"rpm": 18000, "xy_feed_rate": 900, "z_feed_rate": 150, "step_over": 1.5, "step_down": 2},
+		"vbit": {"shape": "vbit", "diameter": 6, "angle": 60, "tip_diameter": 0.2},
+		"inline": {"shape": "vbit90", "diameter": 6},
+		"profile": {"profile": "tool.csv"},
+		"broken": {"shape": "bull", "diameter": 4, "corner_radius": 3},
+		"no-diameter": {"shape": "ball", "rpm": 12000}
+	}`
+	if err := os.WriteFile(path, []byte(json), 0644); err != nil {
+		t.Fatalf("can't write library: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tool.csv"), []byte("0,0\n1,1\n"), 0644); err != nil {
+		t.Fatalf("can't write profile: %v", err)
+	}
+
+	lib, err := LoadToolLibrary(path)
+	if err != nil {
+		t.Fatalf("can't load library: %v", err)
+	}
+
+	if _, err := lib.Get("bogus"); err == nil {
+		t.Errorf("unrecognised tool should be an error")
+	}
+
+	for name, want := range map[string]string{"bull": "bull0.5", "vbit": "vbit60tip0.2", "inline": "vbit90"} {
+		e, _ := lib.Get(name)
+		if got := e.ToolShape(); got != want {
+			t.Errorf("%s: shape should be %q, got %q", name, want, got)
+		}
+	}
+
+	e, _ := lib.Get("profile")
+	tool, err := e.NewTool()
+	if err != nil {
+		t.Fatalf("can't make profile tool (path should be relative to the library): %v", err)
+	}
+	checkFloat(t, "profile tool radius", tool.Radius(), 1)
+
+	e, _ = lib.Get("broken")
+	if _, err := e.NewTool(); err == nil {
+		t.Errorf("invalid tool should be an error")
+	}
+
+	// rather than a tool of no size
+	e, _ = lib.Get("no-diameter")
+	if _, err := e.NewTool(); err == nil {
+		t.Errorf("shape without a diameter should be an error")
+	}
+	opt := DefaultOptions()
+	if err := e.Apply(&opt); err == nil {
+		t.Errorf("applying a shape without a diameter should be an error")
+	}
+
+	// everything from the library
+	opt = DefaultOptions()
+	e, _ = lib.Get("bull")
+	if err := e.Apply(&opt); err != nil {
+		t.Fatalf("can't apply tool: %v", err)
+	}
+	if _, ok := opt.Tool.(*BullNoseEndMill); !ok || opt.Tool.Radius() != 2 {
+		t.Errorf("tool should be a 4mm bull-nose, got %#v", opt.Tool)
+	}
+	if opt.ToolNumber != 3 || opt.RPM != 18000 || opt.XYFeed != 900 || opt.ZFeed != 150 || opt.StepOver != 1.5 || opt.StepDown != 2 {
+		t.Errorf("cutting data not applied: %+v", opt)
+	}
+	if opt.FluteLength != 12 || opt.ShankDiameter != 6 {
+		t.Errorf("flute length and shank diameter not applied: %v, %v", opt.FluteLength, opt.ShankDiameter)
+	}
+
+	// explicit options take priority
+	opt = DefaultOptions()
+	opt.Explicit["tool-shape"] = true
+	opt.Explicit["speed"] = true
+	opt.RPM = 12000
+	if err := e.Apply(&opt); err != nil {
+		t.Fatalf("can't apply tool: %v", err)
+	}
+	if _, ok := opt.Tool.(*BallEndMill); !ok {
+		t.Errorf("explicit tool should be kept, got %#v", opt.Tool)
+	}
+	if opt.RPM != 12000 || opt.XYFeed != 900 {
+		t.Errorf("explicit speed should be kept and feed rate applied, got %v, %v", opt.RPM, opt.XYFeed)
+	}
+
+	// unknown fields are probably typos
+	if err := os.WriteFile(path, []byte(`{"x": {"shape": "ball", "diamter": 3}}`), 0644); err != nil {
+		t.Fatalf("can't write library: %v", err)
+	}
+	if _, err := LoadToolLibrary(path); err == nil {
+		t.Errorf("unknown field should be an error")
+	}
+}
@@ -0,0 +1,559 @@
+package pngcam
+
+import (
+	"math"
+)
+
+type FeedType int
+
+const (
+	RapidFeed FeedType = iota
+	CuttingFeed
+)
+
+// Toolpoint is a position of the tip of the tool, and the kind of move to get
+// there; in rotary mode Y is the angle of the A axis in degrees
+type Toolpoint struct {
+	X    float64
+	Y    float64
+	Z    float64
+	Feed FeedType
+}
+
+type ToolpathSegment struct {
+	points []Toolpoint
+}
+
+type Toolpath struct {
+	segments []ToolpathSegment
+}
+
+func NewToolpathSegment() ToolpathSegment {
+	return ToolpathSegment{
+		points: []Toolpoint{},
+	}
+}
+
+func NewToolpath() Toolpath {
+	return Toolpath{
+		segments: []ToolpathSegment{},
+	}
+}
+
+// Points returns the toolpoints in the segment, in order
+func (seg *ToolpathSegment) Points() []Toolpoint {
+	return seg.points
+}
+
+func (seg *ToolpathSegment) Append(t Toolpoint) {
+	seg.points = append(seg.points, t)
+}
+
+func (seg *ToolpathSegment) AppendSegment(more *ToolpathSegment) {
+	for i := range more.points {
+		seg.Append(more.points[i])
+	}
+}
+
+// Simplified returns a copy of the segment with redundant points removed. With
+// a tolerance of 0, only exactly collinear points are removed; otherwise points
+// are removed as long as the simplified path stays within tolerance of every
+// original point, and never passes below any of them (so it can't gouge)
+func (seg *ToolpathSegment) Simplified(tolerance float64) ToolpathSegment {
+	if tolerance > 0 {
+		return seg.SimplifiedWithin(tolerance)
+	}
+
+	newseg := NewToolpathSegment()
+
+	if len(seg.points) == 0 {
+		return newseg
+	}
+
+	newseg.Append(seg.points[0])
+
+	if len(seg.points) == 1 {
+		return newseg
+	}
+
+	epsilon := 0.00001
+
+	prev := seg.points[1]
+
+	for i := 2; i < len(seg.points); i++ {
+		first := newseg.points[len(newseg.points)-1]
+		cur := seg.points[i]
+
+		prev_xy := math.Atan2(prev.Y-first.Y, prev.X-first.X)
+		cur_xy := math.Atan2(cur.Y-prev.Y, cur.X-prev.X)
+		prev_xz := math.Atan2(prev.Z-first.Z, prev.X-first.X)
+		cur_xz := math.Atan2(cur.Z-prev.Z, cur.X-prev.X)
+		prev_yz := math.Atan2(prev.Z-first.Z, prev.Y-first.Y)
+		cur_yz := math.Atan2(cur.Z-prev.Z, cur.Y-prev.Y)
+
+		// if the route first->prev has the same angle as prev->cur, then first->prev->cur is
+		// a straight line, so we can remove prev and just go straight from first->cur
+
+		if math.Abs(cur_xy-prev_xy) > epsilon || math.Abs(cur_xz-prev_xz) > epsilon || math.Abs(cur_yz-prev_yz) > epsilon {
+			newseg.Append(prev)
+		}
+		prev = cur
+	}
+
+	newseg.Append(prev)
+
+	return newseg
+}
+
+// SimplifiedWithin simplifies the segment with the Douglas-Peucker algorithm in
+// 3D, with the extra constraint that no point may be removed if the line that
+// replaces it would pass below it
+func (seg *ToolpathSegment) SimplifiedWithin(tolerance float64) ToolpathSegment {
+	n := len(seg.points)
+	if n <= 2 {
+		newseg := NewToolpathSegment()
+		newseg.AppendSegment(seg)
+		return newseg
+	}
+
+	keep := make([]bool, n)
+	keep[0] = true
+	keep[n-1] = true
+
+	// rapid moves are never simplified away
+	for i := range seg.points {
+		if seg.points[i].Feed == RapidFeed {
+			keep[i] = true
+		}
+	}
+
+	type span struct{ a, b int }
+	stack := []span{}
+	a := 0
+	for b := 1; b < n; b++ {
+		if keep[b] {
+			stack = append(stack, span{a, b})
+			a = b
+		}
+	}
+
+	for len(stack) > 0 {
+		sp := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		start := seg.points[sp.a]
+		end := seg.points[sp.b]
+
+		worst := -1
+		worstDist := tolerance
+		for i := sp.a + 1; i < sp.b; i++ {
+			p := seg.points[i]
+			dist := pointLineDistance(start, end, p)
+			if lineZAt(start, end, p) < p.Z-0.000001 {
+				// removing this point would gouge, so it has to be kept
+				// regardless of tolerance, but we still want to split at
+				// the most significant point first
+				dist += tolerance
+			}
+			if dist > worstDist {
+				worst = i
+				worstDist = dist
+			}
+		}
+
+		if worst >= 0 {
+			keep[worst] = true
+			stack = append(stack, span{sp.a, worst}, span{worst, sp.b})
+		}
+	}
+
+	newseg := NewToolpathSegment()
+	for i := range seg.points {
+		if keep[i] {
+			newseg.Append(seg.points[i])
+		}
+	}
+
+	return newseg
+}
+
+// distance from p to the closest point on the line segment from a to b
+func pointLineDistance(a, b, p Toolpoint) float64 {
+	dx := b.X - a.X
+	dy := b.Y - a.Y
+	dz := b.Z - a.Z
+	lenSqr := dx*dx + dy*dy + dz*dz
+
+	k := 0.0
+	if lenSqr > 0 {
+		k = ((p.X-a.X)*dx + (p.Y-a.Y)*dy + (p.Z-a.Z)*dz) / lenSqr
+		k = math.Max(0, math.Min(1, k))
+	}
+
+	ex := a.X + k*dx - p.X
+	ey := a.Y + k*dy - p.Y
+	ez := a.Z + k*dz - p.Z
+	return math.Sqrt(ex*ex + ey*ey + ez*ez)
+}
+
+// height of the line from a to b at the point in the XY plane closest to p;
+// if the line is vertical then it never passes over p, so this returns +Inf
+func lineZAt(a, b, p Toolpoint) float64 {
+	dx := b.X - a.X
+	dy := b.Y - a.Y
+	lenSqr := dx*dx + dy*dy
+
+	if lenSqr < 0.000001 {
+		return math.Inf(1)
+	}
+
+	k := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / lenSqr
+	k = math.Max(0, math.Min(1, k))
+
+	return a.Z + k*(b.Z-a.Z)
+}
+
+func (seg *ToolpathSegment) Reversed() ToolpathSegment {
+	newseg := NewToolpathSegment()
+
+	for i := len(seg.points) - 1; i >= 0; i-- {
+		newseg.points = append(newseg.points, seg.points[i])
+	}
+
+	return newseg
+}
+
+func (seg *ToolpathSegment) OmitTopAndBottom(opt *Options) *Toolpath {
+	tp := NewToolpath()
+
+	newseg := NewToolpathSegment()
+
+	// XXX: why does this need to be so large? is it because we're not always
+	// sampling the cutter in the very centre, so sometimes we think we can cut
+	// to z=-0.005 even when it should be exactly 0?
+	epsilon := 0.01
+
+	for i := range seg.points {
+		omit := false
+		if opt.OmitTop && seg.points[i].Z > -epsilon {
+			omit = true
+		}
+		if opt.OmitBottom && seg.points[i].Z < -opt.Depth+epsilon {
+			omit = true
+		}
+		if omit {
+			tp.Append(newseg)
+			newseg = NewToolpathSegment()
+		} else {
+			newseg.Append(seg.points[i])
+		}
+	}
+
+	tp.Append(newseg)
+
+	return &tp
+}
+
+func (seg *ToolpathSegment) RampEntry() ToolpathSegment {
+	newseg := NewToolpathSegment()
+
+	r := rampEntry{out: newseg.Append}
+	for _, p := range seg.points {
+		r.Add(p)
+	}
+	r.Close()
+
+	return newseg
+}
+
+// rampEntry does the work of RampEntry() a point at a time, so that it can be
+// applied to a whole program without holding it in memory; each point only
+// depends on the points either side of it. The points come out through out,
+// and like RampEntry() the first point is dropped if there are more than 2
+type rampEntry struct {
+	out  func(Toolpoint)
+	n    int
+	last Toolpoint
+	p    Toolpoint
+}
+
+func (r *rampEntry) Add(next Toolpoint) {
+	if r.n >= 2 {
+		r.ramp(r.last, r.p, next)
+	}
+	r.last = r.p
+	r.p = next
+	r.n++
+}
+
+// Close outputs whatever is still held back
+func (r *rampEntry) Close() {
+	if r.n == 2 {
+		r.out(r.last)
+	}
+	if r.n > 0 {
+		r.out(r.p)
+	}
+}
+
+// ramp outputs p, going from last to next, with a ramp in front of it if need be
+func (r *rampEntry) ramp(last, p, next Toolpoint) {
+	// when a toolpoint moves down in Z, at more than 30 degrees, ramp it along a straight line going along subsequent
+	// segments; range for line can be found by walking along segments that are in a straight line, until we reach a Z
+	// point that is halfway between current Z and target Z
+
+	maxPlungeAngle := 30 * math.Pi / 180 // radians from horizontal
+	minRampDistance := 0.01              // avoid dividing by 0
+
+	// don't ramp on rapids
+	if p.Feed == RapidFeed {
+		r.out(p)
+		return
+	}
+
+	dxLast := p.X - last.X
+	dyLast := p.Y - last.Y
+	dzLast := p.Z - last.Z
+	dxyLast := math.Sqrt(dxLast*dxLast + dyLast*dyLast)
+
+	plungeAngle := math.Atan2(-dzLast, dxyLast)
+	if plungeAngle < maxPlungeAngle { // already within allowable range
+		r.out(p)
+		return
+	}
+
+	// TODO: when the next-next moves are in the same xy direction (but,
+	// for example, different Z) then we can consider it as well to see if
+	// it provides clearance for a longer ramp
+	dxNext := next.X - p.X
+	dyNext := next.Y - p.Y
+	dzNext := next.Z - p.Z
+	dxyNext := math.Sqrt(dxNext*dxNext + dyNext*dyNext)
+
+	if dxyNext < minRampDistance { // not enough room
+		r.out(p)
+		return
+	}
+
+	// now we need to replace p with 2 horizontal moves going downwards at
+	// maxPlungeAngle; the first move goes in the direction of p => next,
+	// and the second one goes in the opposite direction, to land at p;
+	// if there is not enough horizontal distance between p and next then
+	// we just ramp in whatever distance there is and accept the overly-
+	// steep angle (should we instead do multiple ramps?)
+
+	// the height we need to go down is dzLast, and we're starting from
+	// last so the first leg goes down to z=p.z-dzLast/2 and the second leg
+	// ends up at p, so we'll just leave p unchanged for that
+
+	// how steep is the next leg of the toolpath?
+	availableRampAngle := math.Atan2(dzNext, dxyNext)
+
+	// how steep would our ramp need to be to finish before passing the next point?
+	impliedAvailableRampAngle := math.Atan2(-dzLast/2, dxyNext)
+
+	// use whichever limit forces the ramp angle to be steepest, so that
+	// we don't exceed any limit
+	rampAngle := maxPlungeAngle
+	if availableRampAngle > rampAngle {
+		rampAngle = availableRampAngle
+	}
+	if impliedAvailableRampAngle > rampAngle {
+		rampAngle = impliedAvailableRampAngle
+	}
+
+	dxyRamp := -(dzLast / 2) / math.Tan(rampAngle)
+	k := dxyRamp / dxyNext
+	dxRamp := k * dxNext
+	dyRamp := k * dyNext
+
+	// if splitting this move into 2 ramps causes the second ramp to be steeper
+	// than the original move, then just keep the original move instead
+	plungeAngle2 := math.Atan2(-dzLast/2, math.Abs(dxyRamp)-dxyLast)
+	if plungeAngle2 > plungeAngle {
+		r.out(p)
+		return
+	}
+
+	r.out(Toolpoint{last.X + dxRamp, last.Y + dyRamp, p.Z - dzLast/2, CuttingFeed})
+	r.out(p)
+}
+
+func (seg *ToolpathSegment) CycleTime(opt Options) float64 {
+	planner := newMotionPlanner(opt)
+	for _, p := range seg.points {
+		planner.Add(p)
+	}
+	return planner.Time()
+}
+
+func (tp *Toolpath) Simplified(tolerance float64) *Toolpath {
+	newtp := NewToolpath()
+
+	for i := range tp.segments {
+		newtp.Append(tp.segments[i].Simplified(tolerance))
+	}
+
+	return &newtp
+}
+
+func (tp *Toolpath) RampEntry(opt Options) *Toolpath {
+	newtp := NewToolpath()
+
+	newtp.Append(tp.AsOneSegment(opt).RampEntry())
+
+	return &newtp
+}
+
+func (tp *Toolpath) Sorted() *Toolpath {
+	newtp := NewToolpath()
+
+	needsegs := make(map[int]*ToolpathSegment)
+
+	last := Toolpoint{0, 0, 0, RapidFeed}
+	gotFirstPoint := false
+
+	// take a copy of every segment we need
+	for i := range tp.segments {
+		if len(tp.segments[i].points) > 0 {
+			if !gotFirstPoint {
+				last = tp.segments[i].points[0]
+				gotFirstPoint = true
+			}
+			needsegs[i] = &tp.segments[i]
+		}
+	}
+
+	// grab the segment which starts nearest to the end point of the last
+	// segment, move it into our new toolpath, repeat until done
+	for len(needsegs) > 0 {
+		minDist := math.Inf(1)
+		minIdx := 0
+		minReversed := false
+
+		for i, _ := range needsegs {
+			seg := needsegs[i]
+			dx := seg.points[0].X - last.X
+			dy := seg.points[0].Y - last.Y
+			dz := seg.points[0].Z - last.Z
+			dist := math.Sqrt(dx*dx + dy*dy + dz*dz)
+			if dist < minDist {
+				minDist = dist
+				minIdx = i
+				minReversed = false
+			}
+
+			// try the same segment again, but in reverse
+			n := len(seg.points) - 1
+			dx = seg.points[n].X - last.X
+			dy = seg.points[n].Y - last.Y
+			dz = seg.points[n].Z - last.Z
+			dist = math.Sqrt(dx*dx + dy*dy + dz*dz)
+			if dist < minDist {
+				minDist = dist
+				minIdx = i
+				minReversed = true
+			}
+		}
+
+		minSeg := needsegs[minIdx]
+		if minReversed {
+			last = minSeg.points[0]
+			newtp.Append(minSeg.Reversed())
+		} else {
+			last = minSeg.points[len(minSeg.points)-1]
+			newtp.Append(*minSeg)
+		}
+
+		delete(needsegs, minIdx)
+	}
+
+	return &newtp
+}
+
+// Segments returns the segments of the toolpath, which AsOneSegment() joins
+// up with rapid moves
+func (tp *Toolpath) Segments() []ToolpathSegment {
+	return tp.segments
+}
+
+func (tp *Toolpath) Append(seg ToolpathSegment) {
+	tp.segments = append(tp.segments, seg)
+}
+
+func (tp *Toolpath) AppendToolpath(more *Toolpath) {
+	for i := range more.segments {
+		tp.Append(more.segments[i])
+	}
+}
+
+func (tp *Toolpath) AsOneSegment(opt Options) *ToolpathSegment {
+	seg := NewToolpathSegment()
+	tp.eachPoint(opt, seg.Append)
+	return &seg
+}
+
+// eachPoint gives each point of the toolpath to fn in turn, with the rapid
+// moves in between segments, the same way AsOneSegment() joins them up
+func (tp *Toolpath) eachPoint(opt Options, fn func(Toolpoint)) {
+	for i := range tp.segments {
+		seg := &tp.segments[i]
+		if len(seg.points) == 0 {
+			continue
+		}
+
+		for _, p := range approach(seg, opt) {
+			fn(p)
+		}
+		for _, p := range seg.points {
+			fn(p)
+		}
+		fn(retract(seg, opt))
+	}
+}
+
+// approach returns the rapid moves from safe Z down to just above the start
+// of seg
+func approach(seg *ToolpathSegment, opt Options) []Toolpoint {
+	// TODO: use RapidPath()
+	p0 := seg.points[0]
+
+	// move to the start point of this segment
+	points := []Toolpoint{{p0.X, p0.Y, opt.SafeZ, RapidFeed}}
+
+	// rapid down to stepDown above start height?
+	if p0.Z+opt.StepDown < opt.SafeZ {
+		points = append(points, Toolpoint{p0.X, p0.Y, p0.Z + opt.StepDown, RapidFeed})
+	}
+
+	return points
+}
+
+// retract returns the rapid move from the end of seg back up to safe Z
+func retract(seg *ToolpathSegment, opt Options) Toolpoint {
+	pLast := seg.points[len(seg.points)-1]
+	return Toolpoint{pLast.X, pLast.Y, opt.SafeZ, RapidFeed}
+}
+
+func (tp *Toolpath) RapidPath(a, b Toolpoint, opt Options) ToolpathSegment {
+	seg := NewToolpathSegment()
+
+	// move up to safe Z
+	seg.Append(Toolpoint{a.X, a.Y, opt.SafeZ, RapidFeed})
+
+	// move above next point
+	seg.Append(Toolpoint{b.X, b.Y, opt.SafeZ, RapidFeed})
+
+	// rapid down to safe Z above?
+	if b.Z+opt.StepDown < opt.SafeZ {
+		seg.Append(Toolpoint{b.X, b.Y, b.Z + opt.StepDown, RapidFeed})
+	}
+
+	return seg
+}
+
+func (tp *Toolpath) CycleTime(opt Options) float64 {
+	planner := newMotionPlanner(opt)
+	tp.eachPoint(opt, planner.Add)
+	return planner.Time()
+}
@@ -0,0 +1,195 @@
+package pngcam
+
+import (
+	"math"
+	"testing"
+)
+
+func nonEmptySegments(tp *Toolpath) []ToolpathSegment {
+	segs := []ToolpathSegment{}
+	for _, seg := range tp.segments {
+		if len(seg.points) > 0 {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+func TestToolpaths(t *testing.T) {
+	opt := Options{
+		SafeZ:     5,
+		RapidFeed: 10000,
+		XYFeed:    2000,
+		ZFeed:     200,
+		Post:      &LinuxCNCPost{},
+	}
+
+	seg1 := ToolpathSegment{
+		points: []Toolpoint{
+			{0, 0, 0, CuttingFeed},
+			{1, 1, 0, CuttingFeed},
+			{0, 1, 0, CuttingFeed},
+			{0, 1, -10, CuttingFeed},
+			{0, 10, -10, CuttingFeed},
+			{0, 10, 0, CuttingFeed},
+		},
+	}
+	gcode1 := seg1.ToGcode(opt)
+
+	if gcode1 == "" {
+		t.Errorf("incorrect gcode1; got empty string")
+	}
+
+	seg2 := ToolpathSegment{
+		points: []Toolpoint{
+			{20, 0, 0, CuttingFeed},
+			{21, 1, 0, CuttingFeed},
+			{20, 1, 0, CuttingFeed},
+			{20, 1, -10, CuttingFeed},
+			{20, 10, -10, CuttingFeed},
+			{20, 10, 0, CuttingFeed},
+		},
+	}
+	gcode2 := seg2.ToGcode(opt)
+
+	if gcode2 == "" {
+		t.Errorf("incorrect gcode2; got empty string")
+	}
+
+	path := Toolpath{segments: []ToolpathSegment{seg1, seg2}}
+	gcode := path.ToGcode(opt)
+
+	if gcode == "" {
+		t.Errorf("incorrect gcode; got empty string")
+	}
+}
+
+func TestOmitTopAndBottom(t *testing.T) {
+	seg := ToolpathSegment{
+		points: []Toolpoint{
+			{0, 0, 0, CuttingFeed},
+			{1, 0, -1, CuttingFeed},
+			{2, 0, -10, CuttingFeed},
+			{3, 0, -5, CuttingFeed},
+			{4, 0, 0, CuttingFeed},
+		},
+	}
+
+	optTop := &Options{OmitTop: true, Depth: 10}
+	gotTop := seg.OmitTopAndBottom(optTop)
+	gotTopSegs := nonEmptySegments(gotTop)
+	if len(gotTopSegs) != 1 || len(gotTopSegs[0].points) != 3 {
+		t.Fatalf("omit-top should keep one 3-point segment, got %#v", gotTopSegs)
+	}
+
+	optBottom := &Options{OmitBottom: true, Depth: 10}
+	gotBottom := seg.OmitTopAndBottom(optBottom)
+	gotBottomSegs := nonEmptySegments(gotBottom)
+	if len(gotBottomSegs) != 2 {
+		t.Fatalf("omit-bottom should split into two segments, got %d", len(gotBottomSegs))
+	}
+	if len(gotBottomSegs[0].points) != 2 || len(gotBottomSegs[1].points) != 2 {
+		t.Fatalf("omit-bottom should keep two 2-point segments, got %#v", gotBottomSegs)
+	}
+
+	optBoth := &Options{OmitTop: true, OmitBottom: true, Depth: 10}
+	gotBoth := seg.OmitTopAndBottom(optBoth)
+	gotBothSegs := nonEmptySegments(gotBoth)
+	if len(gotBothSegs) != 2 {
+		t.Fatalf("omit-top+omit-bottom should split into two segments, got %d", len(gotBothSegs))
+	}
+	if len(gotBothSegs[0].points) != 1 || len(gotBothSegs[1].points) != 1 {
+		t.Fatalf("omit-top+omit-bottom should keep two single-point segments, got %#v", gotBothSegs)
+	}
+}
+
+func TestSimplified(t *testing.T) {
+	seg := NewToolpathSegment()
+	zs := []float64{0, 0, 0, -1, -2, -3, -3, -3, -2.99, -1, 0, 0.01, 0}
+	for i, z := range zs {
+		seg.Append(Toolpoint{float64(i), 5, z, CuttingFeed})
+	}
+
+	collinear := seg.Simplified(0)
+	if len(collinear.points) != 9 {
+		t.Errorf("exact simplification should keep 9 points, got %d", len(collinear.points))
+	}
+
+	simple := seg.Simplified(0.05)
+	if len(simple.points) >= len(collinear.points) {
+		t.Errorf("tolerance simplification should remove more points than exact simplification, got %d", len(simple.points))
+	}
+
+	// every original point must be within tolerance of the new path, and
+	// the new path must never pass below any original point
+	for _, p := range seg.points {
+		for i := 1; i < len(simple.points); i++ {
+			a := simple.points[i-1]
+			b := simple.points[i]
+			if p.X < a.X || p.X > b.X {
+				continue
+			}
+			if lineZAt(a, b, p) < p.Z-0.000001 {
+				t.Errorf("simplified path gouges point %v", p)
+			}
+			if pointLineDistance(a, b, p) > 0.05 {
+				t.Errorf("simplified path deviates too far from point %v", p)
+			}
+		}
+	}
+}
+
+func TestFinishingWithinTolerance(t *testing.T) {
+	// the finishing path must stay within tolerance of the toolpoints, which
+	// it wouldn't if the passes were simplified more than once
+	w, h := 200, 10
+	heights := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			heights[y*w+x] = 5 + 3*math.Sin(float64(x)/9)
+		}
+	}
+	opt := DefaultOptions()
+	opt.Width = 40
+	opt.Depth = 10
+	opt.StepOver = 2
+	opt.Tolerance = 0.05
+	opt.Quiet = true
+	opt.Tool, _ = NewTool("ball", 2)
+	job, err := NewJobFromHeights(w, h, heights, &opt)
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+
+	// the first pass, the same way MakeToolpath() steps along it
+	pass := NewToolpathSegment()
+	for x := 0.0; x < opt.Width; x += opt.XStepForward() {
+		pass.Append(Toolpoint{x, 0, job.toolpoints.GetMm(x, 0), CuttingFeed})
+	}
+
+	checked := 0
+	for _, seg := range job.Finishing().Segments() {
+		for i := 1; i < len(seg.points); i++ {
+			a := seg.points[i-1]
+			b := seg.points[i]
+			if a.Y != 0 || b.Y != 0 {
+				continue
+			}
+			for _, p := range pass.points {
+				if p.X < math.Min(a.X, b.X) || p.X > math.Max(a.X, b.X) {
+					continue
+				}
+				checked++
+				if lineZAt(a, b, p) < p.Z-0.000001 {
+					t.Errorf("finishing path gouges point %v", p)
+				}
+				if d := pointLineDistance(a, b, p); d > opt.Tolerance+0.000001 {
+					t.Errorf("finishing path deviates %v from point %v", d, p)
+				}
+			}
+		}
+	}
+	if checked < len(pass.points) {
+		t.Errorf("only checked %d of the %d points in the first pass", checked, len(pass.points))
+	}
+}
@@ -0,0 +1,176 @@
+package pngcam
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProfileTool is any axisymmetric cutter, described by the height of its
+// cutting surface above the tip at each of a list of radii, with straight
+// lines in between. Above the last point it is a cylinder of that radius.
+type ProfileTool struct {
+	radius []float64
+	height []float64
+}
+
+// LoadToolProfile reads a tool profile from a CSV file of radius,height
+// pairs in mm, starting at radius 0 and in increasing order of radius; a
+// header line and lines starting with # are ignored
+func LoadToolProfile(path string) (*ProfileTool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	radius := []float64{}
+	height := []float64{}
+
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		rad, err1 := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		h, err2 := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err1 != nil || err2 != nil {
+			if first {
+				// header
+				continue
+			}
+			// (counting records would miss out comment lines)
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("%s: line %d: can't parse %q as radius,height", path, line, strings.Join(record, ","))
+		}
+
+		radius = append(radius, rad)
+		height = append(height, h)
+	}
+
+	tool, err := NewProfileTool(radius, height)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return tool, nil
+}
+
+// NewProfileTool makes a tool from the height of its cutting surface above
+// the tip at each radius (see LoadToolProfile())
+func NewProfileTool(radius []float64, height []float64) (*ProfileTool, error) {
+	if len(radius) < 2 || len(radius) != len(height) {
+		return nil, fmt.Errorf("tool profile needs at least 2 points")
+	}
+	if radius[0] != 0 {
+		return nil, fmt.Errorf("tool profile must start at radius 0, not %g", radius[0])
+	}
+	for i := range radius {
+		if i > 0 && !(radius[i] > radius[i-1]) {
+			return nil, fmt.Errorf("tool profile radii must increase, but %g comes after %g", radius[i], radius[i-1])
+		}
+		if !(height[i] >= 0) || math.IsInf(height[i], 1) {
+			return nil, fmt.Errorf("tool profile height %g at radius %g must be at least 0", height[i], radius[i])
+		}
+	}
+
+	return &ProfileTool{radius: radius, height: height}, nil
+}
+
+func (t *ProfileTool) Radius() float64 { return t.radius[len(t.radius)-1] }
+
+func (t *ProfileTool) HeightAtRadius(r float64) float64 {
+	if r > t.Radius() {
+		return math.Inf(1)
+	}
+
+	// first point at or beyond r
+	i := sort.SearchFloat64s(t.radius, r)
+	if i == 0 {
+		return t.height[0]
+	}
+	k := (r - t.radius[i-1]) / (t.radius[i] - t.radius[i-1])
+	return t.height[i-1] + k*(t.height[i]-t.height[i-1])
+}
+func (t *ProfileTool) HeightAtRadiusSqr(rSqr float64) float64 {
+	return t.HeightAtRadius(math.Sqrt(rSqr))
+}
+
+// LengthToIntersection treats each straight line of the profile as a cone
+// (or a flat ring) and finds the nearest point where the line from the
+// origin crosses any of them; the line moves away from the tool's axis as it
+// goes, so it can only get into the tool through the cutting surface
+func (t *ProfileTool) LengthToIntersection(xOffset float64, angle float64, z float64) float64 {
+	if math.Abs(xOffset) > t.Radius() {
+		return math.NaN()
+	}
+
+	sin := math.Sin(angle * math.Pi / 180.0)
+	cos := math.Cos(angle * math.Pi / 180.0)
+	if cos <= 0 {
+		return math.NaN()
+	}
+
+	rAt := func(l float64) float64 {
+		y := l * sin
+		return math.Sqrt(xOffset*xOffset + y*y)
+	}
+
+	epsilon := 0.000000001
+	best := math.NaN()
+
+	for i := 1; i < len(t.radius); i++ {
+		r0, r1 := t.radius[i-1], t.radius[i]
+		if r1 < math.Abs(xOffset) {
+			continue
+		}
+
+		// surface height = c + slope.r, and the point at length l along the
+		// line is at height l.cos and radius sqrt(xOffset^2 + (l.sin)^2), so
+		// (l.cos - c)^2 = slope^2.(xOffset^2 + (l.sin)^2)
+		slope := (t.height[i] - t.height[i-1]) / (r1 - r0)
+		c := z + t.height[i-1] - slope*r0
+
+		a := cos*cos - slope*slope*sin*sin
+		b := -2 * c * cos
+		cc := c*c - slope*slope*xOffset*xOffset
+
+		roots := []float64{}
+		if math.Abs(a) < 1e-12 {
+			if b != 0 {
+				roots = append(roots, -cc/b)
+			}
+		} else if disc := b*b - 4*a*cc; disc >= -1e-12*b*b {
+			// (flat rings give a double root, which can come out with a
+			// slightly negative discriminant)
+			q := math.Sqrt(math.Max(0, disc))
+			roots = append(roots, (-b-q)/(2*a), (-b+q)/(2*a))
+		}
+
+		for _, l := range roots {
+			r := rAt(l)
+			// squaring lets in points on the mirror image of the cone, so
+			// check the height as well
+			if l < 0 || r < r0-epsilon || r > r1+epsilon || math.Abs(l*cos-c-slope*r) > 0.000001 {
+				continue
+			}
+			if math.IsNaN(best) || l < best {
+				best = l
+			}
+		}
+	}
+
+	return best
+}
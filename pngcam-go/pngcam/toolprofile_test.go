@@ -0,0 +1,76 @@
+package pngcam
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToolProfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tool.csv")
+	csv := "radius,height\n# 60 degree V-bit with a 1mm flat tip\n0,0\n0.5, 0\n3,4.330127\n"
+	if err := os.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatalf("can't write profile: %v", err)
+	}
+
+	tool, err := LoadToolProfile(path)
+	if err != nil {
+		t.Fatalf("can't load profile: %v", err)
+	}
+	if tool.Radius() != 3 {
+		t.Errorf("tool radius was %v, expected 3", tool.Radius())
+	}
+
+	checkHeightAtRadius(t, tool, 0, 0)
+	checkHeightAtRadius(t, tool, 0.5, 0)
+	checkHeightAtRadius(t, tool, 1.75, 2.1650635)
+	checkHeightAtRadius(t, tool, 3, 4.330127)
+	checkHeightAtRadius(t, tool, 3.5, math.Inf(1))
+
+	vbit, _ := NewTool("vbit60tip1", 6)
+	for _, x := range []float64{0, 0.3, 1, 2.5} {
+		for _, angle := range []float64{-12, -3, 0, 1, 8, 20} {
+			want := vbit.LengthToIntersection(x, angle, 10)
+			got := tool.LengthToIntersection(x, angle, 10)
+			if math.IsNaN(want) != math.IsNaN(got) || math.Abs(got-want) > 0.00001 {
+				t.Errorf("length at %v mm, %v degrees should be %v, got %v", x, angle, want, got)
+			}
+		}
+	}
+
+	// a tool that isn't convex: a flat end mill with a groove in it
+	grooved, err := NewProfileTool([]float64{0, 1, 1.5, 2, 3}, []float64{0, 0, 1, 0, 0})
+	if err != nil {
+		t.Fatalf("can't make grooved tool: %v", err)
+	}
+	checkFloat(t, "length at 0 degrees", grooved.LengthToIntersection(1.25, 0, 10), 10.5)
+	for _, angle := range []float64{5, 9, 10, 11} {
+		// step along the line until we're inside the tool
+		sin := math.Sin(angle * math.Pi / 180)
+		cos := math.Cos(angle * math.Pi / 180)
+		want := 0.0
+		for ; want*cos-10 < grooved.HeightAtRadius(want*sin); want += 0.000001 {
+		}
+		checkFloat(t, "length into groove", grooved.LengthToIntersection(0, angle, 10), want)
+	}
+
+	for _, bad := range []string{"", "0,0\n", "1,0\n2,0\n", "0,0\n2,0\n1,0\n", "0,0\n1,-1\n", "0,0\n1,x\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatalf("can't write profile: %v", err)
+		}
+		if _, err := LoadToolProfile(path); err == nil {
+			t.Errorf("profile %q should be an error", bad)
+		}
+	}
+
+	// errors give the line in the file, counting comments
+	if err := os.WriteFile(path, []byte("radius,height\n# comment\n# another\n0,0\n1,x\n"), 0644); err != nil {
+		t.Fatalf("can't write profile: %v", err)
+	}
+	if _, err := LoadToolProfile(path); err == nil || !strings.Contains(err.Error(), "line 5:") {
+		t.Errorf("error should be on line 5, got %v", err)
+	}
+}